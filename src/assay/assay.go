@@ -0,0 +1,210 @@
+// Assay metadata, from an assay manifest: the abbreviation written to AT and
+// used for sample suffixes, priority for the priority resolver, genome build,
+// imputation panel and per-assay QC thresholds
+package assay
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//-----------------------------------------------
+// Assay: metadata for one assay type, QC holds thresholds by parameter file
+// key (TESTNUM, CALLRATE, MAFDELTA, INFOSCORE) overriding the run's values
+//-----------------------------------------------
+type Assay struct {
+	Name     string
+	Abbrev   string
+	Priority int
+	Build    string
+	Panel    string
+	QC       map[string]string
+}
+
+// Manifest columns, QC columns map to the parameter file keys
+const (
+	ColAssay    = "assay"
+	ColAbbrev   = "abbrev"
+	ColPriority = "priority"
+	ColBuild    = "build"
+	ColPanel    = "panel"
+)
+
+var qcColumns = map[string]string{
+	"testnum":   "TESTNUM",
+	"callrate":  "CALLRATE",
+	"mafdelta":  "MAFDELTA",
+	"infoscore": "INFOSCORE",
+}
+
+//-----------------------------------------------
+// Manifest: assays by name
+//-----------------------------------------------
+type Manifest map[string]*Assay
+
+//------------------------------------------------------------------------------
+// Read a manifest: a TSV with a header line naming the columns (#assay first,
+// then any of abbrev, priority, build, panel, testnum, callrate, mafdelta,
+// infoscore), "." or an empty field leaves a value unset
+//------------------------------------------------------------------------------
+func ReadManifest(path string) (Manifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	manifest := make(Manifest)
+	var columns []string
+	scanner := bufio.NewScanner(f)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		text := scanner.Text()
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		if columns == nil {
+			if columns, err = header_columns(text); err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", path, lineno, err)
+			}
+			continue
+		}
+		if strings.HasPrefix(text, "#") {
+			continue
+		}
+		a, err := parse_assay(columns, strings.Split(text, "\t"))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, lineno, err)
+		}
+		if _, ok := manifest[a.Name]; ok {
+			return nil, fmt.Errorf("%s:%d: assay %s listed twice", path, lineno, a.Name)
+		}
+		manifest[a.Name] = a
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if columns == nil {
+		return nil, fmt.Errorf("%s: no header line", path)
+	}
+	return manifest, nil
+}
+
+func header_columns(text string) ([]string, error) {
+	columns := strings.Split(strings.TrimPrefix(text, "#"), "\t")
+	if columns[0] != ColAssay {
+		return nil, fmt.Errorf("header must start #%s", ColAssay)
+	}
+	seen := make(map[string]bool)
+	for _, col := range columns {
+		switch col {
+		case ColAssay, ColAbbrev, ColPriority, ColBuild, ColPanel:
+		default:
+			if _, ok := qcColumns[col]; !ok {
+				return nil, fmt.Errorf("unknown column %q", col)
+			}
+		}
+		if seen[col] {
+			return nil, fmt.Errorf("column %q given twice", col)
+		}
+		seen[col] = true
+	}
+	return columns, nil
+}
+
+func parse_assay(columns []string, fields []string) (*Assay, error) {
+	if len(fields) > len(columns) {
+		return nil, fmt.Errorf("%d fields, the header has %d columns", len(fields), len(columns))
+	}
+	a := &Assay{QC: make(map[string]string)}
+	for i, value := range fields {
+		value = strings.TrimSpace(value)
+		if value == "." || value == "" {
+			continue
+		}
+		switch col := columns[i]; col {
+		case ColAssay:
+			a.Name = value
+		case ColAbbrev:
+			a.Abbrev = value
+		case ColPriority:
+			priority, err := strconv.Atoi(value)
+			if err != nil || priority < 1 {
+				return nil, fmt.Errorf("priority %q is not a positive integer", value)
+			}
+			a.Priority = priority
+		case ColBuild:
+			a.Build = value
+		case ColPanel:
+			a.Panel = value
+		case "testnum":
+			if _, err := strconv.Atoi(value); err != nil {
+				return nil, fmt.Errorf("%s %q is not an integer", col, value)
+			}
+			a.QC[qcColumns[col]] = value
+		default:
+			if _, err := strconv.ParseFloat(value, 64); err != nil {
+				return nil, fmt.Errorf("%s %q is not a number", col, value)
+			}
+			a.QC[qcColumns[col]] = value
+		}
+	}
+	if a.Name == "" {
+		return nil, fmt.Errorf("no assay name")
+	}
+	return a, nil
+}
+
+//------------------------------------------------------------------------------
+// Metadata for an assay, an assay not in the manifest (or a nil manifest)
+// gets no metadata
+//------------------------------------------------------------------------------
+func (m Manifest) Get(name string) *Assay {
+	if a, ok := m[name]; ok {
+		return a
+	}
+	return &Assay{Name: name, QC: map[string]string{}}
+}
+
+//------------------------------------------------------------------------------
+// The AT abbreviation of an assay: from the manifest, or the assay name
+// itself
+//------------------------------------------------------------------------------
+func (m Manifest) Abbrev(name string) string {
+	if a := m.Get(name); a.Abbrev != "" {
+		return a.Abbrev
+	}
+	return name
+}
+
+//------------------------------------------------------------------------------
+// Assays by priority (1 highest), assays without one after, ties and
+// unprioritised assays in the order given
+//------------------------------------------------------------------------------
+func (m Manifest) ByPriority(names []string) []string {
+	sorted := append([]string{}, names...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		pi, pj := m.Get(sorted[i]).Priority, m.Get(sorted[j]).Priority
+		return pi > 0 && (pj == 0 || pi < pj)
+	})
+	return sorted
+}
+
+//------------------------------------------------------------------------------
+// QC parameters for an assay: the run's values (by parameter file key) with
+// the assay's own thresholds in place
+//------------------------------------------------------------------------------
+func (m Manifest) Params(name string, run map[string]string) map[string]string {
+	params := make(map[string]string, len(run))
+	for key, value := range run {
+		params[key] = value
+	}
+	for key, value := range m.Get(name).QC {
+		params[key] = value
+	}
+	return params
+}
@@ -0,0 +1,838 @@
+// BCF2 (binary VCF) reading and writing, records are converted to and from
+// the split text fields used elsewhere
+package bcf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"vcfheader"
+)
+
+// Magic bytes, BCF major version 2, minor version 2
+var Magic = []byte{'B', 'C', 'F', 2, 2}
+
+// typed value types
+const (
+	typeNull  = 0
+	typeInt8  = 1
+	typeInt16 = 2
+	typeInt32 = 3
+	typeFloat = 5
+	typeChar  = 7
+)
+
+// missing and end-of-vector values
+const (
+	int8Missing   = -128
+	int8EOV       = -127
+	int16Missing  = -32768
+	int16EOV      = -32767
+	int32Missing  = math.MinInt32
+	int32EOV      = math.MinInt32 + 1
+	floatMissing  = 0x7F800001
+	floatEOV      = 0x7F800002
+	int8MinValue  = -120
+	int16MinValue = -32760
+)
+
+// fixed columns of a text record
+const (
+	chrIdx       = 0
+	posnIdx      = 1
+	varIdx       = 2
+	refIdx       = 3
+	altIdx       = 4
+	qualIdx      = 5
+	filtIdx      = 6
+	infoIdx      = 7
+	fmtIdx       = 8
+	firstGenoIdx = 9
+)
+
+var ErrNotBCF = errors.New("not a BCF2 stream")
+
+//-----------------------------------------------
+// Dictionaries: the string (FILTER/INFO/FORMAT id) and contig
+// dictionaries of a header, with the declared types
+//-----------------------------------------------
+type Dictionaries struct {
+	Strings     []string
+	StringIdx   map[string]int
+	Contigs     []string
+	ContigIdx   map[string]int
+	InfoTypes   map[string]string
+	FormatTypes map[string]string
+	Samples     []string
+}
+
+//------------------------------------------------------------------------------
+// Build dictionaries from header meta lines and sample names: PASS is
+// string 0, then FILTER, INFO and FORMAT ids in header order (or at an
+// explicit IDX), contigs in header order
+//------------------------------------------------------------------------------
+func MakeDictionaries(meta_lines []string, samples []string) *Dictionaries {
+	d := &Dictionaries{StringIdx: make(map[string]int), ContigIdx: make(map[string]int),
+		InfoTypes: make(map[string]string), FormatTypes: make(map[string]string), Samples: samples}
+	d.addString("PASS", -1)
+	for _, line := range meta_lines {
+		key, _, fields := vcfheader.ParseMetaLine(line)
+		id, ok := fields["ID"]
+		if !ok {
+			continue
+		}
+		idx := -1
+		if v, ok := fields["IDX"]; ok {
+			idx, _ = strconv.Atoi(v)
+		}
+		switch key {
+		case "FILTER", "INFO", "FORMAT":
+			d.addString(id, idx)
+			if key == "INFO" {
+				d.InfoTypes[id] = fields["Type"]
+			} else if key == "FORMAT" {
+				d.FormatTypes[id] = fields["Type"]
+			}
+		case "contig":
+			if _, ok := d.ContigIdx[id]; !ok {
+				if idx < 0 {
+					idx = len(d.Contigs)
+				}
+				for len(d.Contigs) <= idx {
+					d.Contigs = append(d.Contigs, "")
+				}
+				d.Contigs[idx] = id
+				d.ContigIdx[id] = idx
+			}
+		}
+	}
+	return d
+}
+
+func (d *Dictionaries) addString(id string, idx int) {
+	if _, ok := d.StringIdx[id]; ok {
+		return
+	}
+	if idx < 0 {
+		idx = len(d.Strings)
+	}
+	for len(d.Strings) <= idx {
+		d.Strings = append(d.Strings, "")
+	}
+	d.Strings[idx] = id
+	d.StringIdx[id] = idx
+}
+
+//-----------------------------------------------
+// Reader: BCF records from a decompressed stream
+//-----------------------------------------------
+type Reader struct {
+	r       io.Reader
+	Meta    []string
+	Samples []string
+	Dict    *Dictionaries
+	buf     []byte
+}
+
+//------------------------------------------------------------------------------
+// Read the magic and header text of a decompressed BCF stream
+//------------------------------------------------------------------------------
+func NewReader(r io.Reader) (*Reader, error) {
+	magic := make([]byte, len(Magic))
+	if _, err := io.ReadFull(r, magic); err != nil || !bytes.Equal(magic, Magic) {
+		return nil, ErrNotBCF
+	}
+	var l_text uint32
+	if err := binary.Read(r, binary.LittleEndian, &l_text); err != nil {
+		return nil, err
+	}
+	text := make([]byte, l_text)
+	if _, err := io.ReadFull(r, text); err != nil {
+		return nil, err
+	}
+	br := &Reader{r: r}
+	for _, line := range strings.Split(strings.TrimRight(string(text), "\x00\n"), "\n") {
+		if strings.HasPrefix(line, "##") {
+			br.Meta = append(br.Meta, line)
+		} else if strings.HasPrefix(line, "#") {
+			cols := strings.Split(line, "\t")
+			if len(cols) > firstGenoIdx {
+				br.Samples = cols[firstGenoIdx:]
+			}
+		}
+	}
+	br.Dict = MakeDictionaries(br.Meta, br.Samples)
+	return br, nil
+}
+
+//------------------------------------------------------------------------------
+// Next record as text fields, io.EOF after the last record
+//------------------------------------------------------------------------------
+func (br *Reader) Read() ([]string, error) {
+	lens := make([]byte, 8)
+	if _, err := io.ReadFull(br.r, lens); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("truncated BCF record")
+		}
+		return nil, err
+	}
+	l_shared := binary.LittleEndian.Uint32(lens)
+	l_indiv := binary.LittleEndian.Uint32(lens[4:])
+	if cap(br.buf) < int(l_shared+l_indiv) {
+		br.buf = make([]byte, l_shared+l_indiv)
+	}
+	data := br.buf[:l_shared+l_indiv]
+	if _, err := io.ReadFull(br.r, data); err != nil {
+		return nil, fmt.Errorf("truncated BCF record")
+	}
+	return br.Dict.Decode(data[:l_shared], data[l_shared:])
+}
+
+//-----------------------------------------------
+// decoder: walks the typed values of a record
+//-----------------------------------------------
+type decoder struct {
+	data []byte
+	off  int
+	err  error
+}
+
+func (dc *decoder) need(n int) bool {
+	if dc.err == nil && dc.off+n > len(dc.data) {
+		dc.err = fmt.Errorf("malformed BCF record")
+	}
+	return dc.err == nil
+}
+
+func (dc *decoder) uint32() uint32 {
+	if !dc.need(4) {
+		return 0
+	}
+	v := binary.LittleEndian.Uint32(dc.data[dc.off:])
+	dc.off += 4
+	return v
+}
+
+// type descriptor: value type and count
+func (dc *decoder) descriptor() (int, int) {
+	if !dc.need(1) {
+		return 0, 0
+	}
+	b := dc.data[dc.off]
+	dc.off++
+	typ, count := int(b&0x0f), int(b>>4)
+	if count == 15 {
+		vals, _ := dc.values(dc.descriptor())
+		if len(vals) == 1 {
+			count = int(vals[0].i)
+		}
+	}
+	return typ, count
+}
+
+//-----------------------------------------------
+// value: one decoded typed value
+//-----------------------------------------------
+type value struct {
+	i       int64
+	f       float32
+	missing bool
+	eov     bool
+}
+
+func typeSize(typ int) int {
+	switch typ {
+	case typeInt8, typeChar:
+		return 1
+	case typeInt16:
+		return 2
+	case typeInt32, typeFloat:
+		return 4
+	}
+	return 0
+}
+
+func (dc *decoder) values(typ int, count int) ([]value, []byte) {
+	size := typeSize(typ)
+	if !dc.need(size * count) {
+		return nil, nil
+	}
+	raw := dc.data[dc.off : dc.off+size*count]
+	dc.off += size * count
+	if typ == typeChar {
+		return nil, raw
+	}
+	vals := make([]value, count)
+	for k := range vals {
+		b := raw[k*size:]
+		switch typ {
+		case typeInt8:
+			v := int8(b[0])
+			vals[k] = value{i: int64(v), missing: v == int8Missing, eov: v == int8EOV}
+		case typeInt16:
+			v := int16(binary.LittleEndian.Uint16(b))
+			vals[k] = value{i: int64(v), missing: v == int16Missing, eov: v == int16EOV}
+		case typeInt32:
+			v := int32(binary.LittleEndian.Uint32(b))
+			vals[k] = value{i: int64(v), missing: v == int32Missing, eov: v == int32EOV}
+		case typeFloat:
+			bits := binary.LittleEndian.Uint32(b)
+			vals[k] = value{f: math.Float32frombits(bits), missing: bits == floatMissing, eov: bits == floatEOV}
+		}
+	}
+	return vals, nil
+}
+
+func (dc *decoder) typedInt() int {
+	vals, _ := dc.values(dc.descriptor())
+	if len(vals) == 0 {
+		return -1
+	}
+	return int(vals[0].i)
+}
+
+func (dc *decoder) typedString() string {
+	typ, count := dc.descriptor()
+	vals, raw := dc.values(typ, count)
+	if raw != nil {
+		return trimChars(raw)
+	}
+	return formatValues(vals, typ)
+}
+
+func trimChars(raw []byte) string {
+	if i := bytes.IndexByte(raw, 0); i >= 0 {
+		raw = raw[:i]
+	}
+	return string(raw)
+}
+
+func formatValues(vals []value, typ int) string {
+	strs := make([]string, 0, len(vals))
+	for _, v := range vals {
+		if v.eov {
+			break
+		}
+		switch {
+		case v.missing:
+			strs = append(strs, ".")
+		case typ == typeFloat:
+			strs = append(strs, strconv.FormatFloat(float64(v.f), 'g', -1, 32))
+		default:
+			strs = append(strs, strconv.FormatInt(v.i, 10))
+		}
+	}
+	if len(strs) == 0 {
+		return "."
+	}
+	return strings.Join(strs, ",")
+}
+
+func formatGenotype(vals []value) string {
+	var sb strings.Builder
+	for k, v := range vals {
+		if v.eov || v.missing {
+			break
+		}
+		if k > 0 {
+			if v.i&1 == 1 {
+				sb.WriteByte('|')
+			} else {
+				sb.WriteByte('/')
+			}
+		}
+		if v.i>>1 == 0 {
+			sb.WriteByte('.')
+		} else {
+			sb.WriteString(strconv.FormatInt(v.i>>1-1, 10))
+		}
+	}
+	if sb.Len() == 0 {
+		return "."
+	}
+	return sb.String()
+}
+
+//------------------------------------------------------------------------------
+// Decode the shared and per-sample parts of a record to text fields
+//------------------------------------------------------------------------------
+func (d *Dictionaries) Decode(shared []byte, indiv []byte) ([]string, error) {
+	dc := &decoder{data: shared}
+	chrom := int(int32(dc.uint32()))
+	posn := int64(int32(dc.uint32())) + 1
+	dc.uint32() // rlen
+	qual := dc.uint32()
+	n_allele_info := dc.uint32()
+	n_fmt_sample := dc.uint32()
+	if dc.err != nil {
+		return nil, dc.err
+	}
+	if chrom < 0 || chrom >= len(d.Contigs) {
+		return nil, fmt.Errorf("BCF contig index %d not in header", chrom)
+	}
+	n_allele, n_info := int(n_allele_info>>16), int(n_allele_info&0xffff)
+	n_fmt, n_sample := int(n_fmt_sample>>24), int(n_fmt_sample&0xffffff)
+
+	rec := make([]string, fmtIdx, firstGenoIdx+n_sample)
+	rec[chrIdx] = d.Contigs[chrom]
+	rec[posnIdx] = strconv.FormatInt(posn, 10)
+	rec[qualIdx] = "."
+	if qual != floatMissing {
+		rec[qualIdx] = strconv.FormatFloat(float64(math.Float32frombits(qual)), 'g', -1, 32)
+	}
+	rec[varIdx] = dc.typedString()
+	if rec[varIdx] == "" {
+		rec[varIdx] = "."
+	}
+	alleles := make([]string, n_allele)
+	for k := range alleles {
+		alleles[k] = dc.typedString()
+	}
+	rec[refIdx], rec[altIdx] = ".", "."
+	if n_allele > 0 {
+		rec[refIdx] = alleles[0]
+	}
+	if n_allele > 1 {
+		rec[altIdx] = strings.Join(alleles[1:], ",")
+	}
+	filters, _ := dc.values(dc.descriptor())
+	filter_ids := make([]string, 0, len(filters))
+	for _, f := range filters {
+		filter_ids = append(filter_ids, d.stringId(int(f.i)))
+	}
+	rec[filtIdx] = "."
+	if len(filter_ids) > 0 {
+		rec[filtIdx] = strings.Join(filter_ids, ";")
+	}
+	info := make([]string, 0, n_info)
+	for k := 0; k < n_info; k++ {
+		key := d.stringId(dc.typedInt())
+		typ, count := dc.descriptor()
+		if typ == typeNull || count == 0 {
+			info = append(info, key)
+			continue
+		}
+		vals, raw := dc.values(typ, count)
+		if raw != nil {
+			info = append(info, key+"="+trimChars(raw))
+		} else {
+			info = append(info, key+"="+formatValues(vals, typ))
+		}
+	}
+	rec[infoIdx] = "."
+	if len(info) > 0 {
+		rec[infoIdx] = strings.Join(info, ";")
+	}
+	if dc.err != nil {
+		return nil, dc.err
+	}
+
+	dc = &decoder{data: indiv}
+	fmt_keys := make([]string, n_fmt)
+	samples := make([][]string, n_sample)
+	for k := 0; k < n_fmt; k++ {
+		fmt_keys[k] = d.stringId(dc.typedInt())
+		typ, count := dc.descriptor()
+		for j := 0; j < n_sample; j++ {
+			vals, raw := dc.values(typ, count)
+			switch {
+			case raw != nil:
+				s := trimChars(raw)
+				if s == "" {
+					s = "."
+				}
+				samples[j] = append(samples[j], s)
+			case fmt_keys[k] == "GT":
+				samples[j] = append(samples[j], formatGenotype(vals))
+			default:
+				samples[j] = append(samples[j], formatValues(vals, typ))
+			}
+		}
+	}
+	if dc.err != nil {
+		return nil, dc.err
+	}
+	if n_fmt > 0 {
+		rec = append(rec, strings.Join(fmt_keys, ":"))
+		for _, sample := range samples {
+			rec = append(rec, strings.Join(sample, ":"))
+		}
+	}
+	return rec, nil
+}
+
+func (d *Dictionaries) stringId(idx int) string {
+	if idx >= 0 && idx < len(d.Strings) {
+		return d.Strings[idx]
+	}
+	return strconv.Itoa(idx)
+}
+
+//-----------------------------------------------
+// encoder: builds the typed values of a record
+//-----------------------------------------------
+type encoder struct {
+	bytes.Buffer
+}
+
+func (ec *encoder) uint32(v uint32) {
+	ec.Write(binary.LittleEndian.AppendUint32(nil, v))
+}
+
+func (ec *encoder) descriptor(typ int, count int) {
+	if count < 15 {
+		ec.WriteByte(byte(count<<4 | typ))
+		return
+	}
+	ec.WriteByte(byte(15<<4 | typ))
+	ec.ints([]int64{int64(count)}, true)
+}
+
+func intType(vals []int64) int {
+	typ := typeInt8
+	for _, v := range vals {
+		if v == int32Missing || v == int32EOV {
+			continue
+		}
+		if v < int16MinValue || v > math.MaxInt16 {
+			return typeInt32
+		}
+		if v < int8MinValue || v > math.MaxInt8 {
+			typ = typeInt16
+		}
+	}
+	return typ
+}
+
+// int vector, values int32Missing/int32EOV are written as the missing
+// and end-of-vector values of the chosen type
+func (ec *encoder) ints(vals []int64, with_descriptor bool) int {
+	typ := intType(vals)
+	if with_descriptor {
+		ec.descriptor(typ, len(vals))
+	}
+	ec.intValues(vals, typ)
+	return typ
+}
+
+func (ec *encoder) intValues(vals []int64, typ int) {
+	for _, v := range vals {
+		switch typ {
+		case typeInt8:
+			switch v {
+			case int32Missing:
+				v = int8Missing
+			case int32EOV:
+				v = int8EOV
+			}
+			ec.WriteByte(byte(int8(v)))
+		case typeInt16:
+			switch v {
+			case int32Missing:
+				v = int16Missing
+			case int32EOV:
+				v = int16EOV
+			}
+			ec.Write(binary.LittleEndian.AppendUint16(nil, uint16(int16(v))))
+		default:
+			ec.uint32(uint32(int32(v)))
+		}
+	}
+}
+
+func (ec *encoder) floats(vals []uint32) {
+	for _, v := range vals {
+		ec.uint32(v)
+	}
+}
+
+func (ec *encoder) typedString(s string) {
+	ec.descriptor(typeChar, len(s))
+	ec.WriteString(s)
+}
+
+func parseInts(str string) []int64 {
+	if str == "." || str == "" {
+		return []int64{int32Missing}
+	}
+	elems := strings.Split(str, ",")
+	vals := make([]int64, len(elems))
+	for k, elem := range elems {
+		v, err := strconv.ParseInt(elem, 10, 32)
+		if err != nil {
+			v = int32Missing
+		}
+		vals[k] = v
+	}
+	return vals
+}
+
+func parseFloats(str string) []uint32 {
+	if str == "." || str == "" {
+		return []uint32{floatMissing}
+	}
+	elems := strings.Split(str, ",")
+	vals := make([]uint32, len(elems))
+	for k, elem := range elems {
+		f, err := strconv.ParseFloat(elem, 32)
+		if err != nil {
+			vals[k] = floatMissing
+		} else {
+			vals[k] = math.Float32bits(float32(f))
+		}
+	}
+	return vals
+}
+
+// GT alleles as BCF ints: (allele+1)<<1 | phased
+func parseGenotype(gt string) []int64 {
+	vals := make([]int64, 0, 2)
+	phased := int64(0)
+	start := 0
+	for k := 0; k <= len(gt); k++ {
+		if k < len(gt) && gt[k] != '/' && gt[k] != '|' {
+			continue
+		}
+		allele := gt[start:k]
+		v := int64(0)
+		if a, err := strconv.ParseInt(allele, 10, 32); err == nil {
+			v = (a + 1) << 1
+		}
+		vals = append(vals, v|phased)
+		if k < len(gt) && gt[k] == '|' {
+			phased = 1
+		} else {
+			phased = 0
+		}
+		start = k + 1
+	}
+	return vals
+}
+
+//------------------------------------------------------------------------------
+// Encode the text fields of a record to BCF shared and per-sample parts,
+// every contig, FILTER, INFO and FORMAT id must be in the dictionaries
+//------------------------------------------------------------------------------
+func (d *Dictionaries) Encode(rec []string) ([]byte, []byte, error) {
+	if len(rec) < firstGenoIdx-1 {
+		return nil, nil, fmt.Errorf("short record: %d fields", len(rec))
+	}
+	chrom, ok := d.ContigIdx[rec[chrIdx]]
+	if !ok {
+		return nil, nil, fmt.Errorf("contig %s not in header", rec[chrIdx])
+	}
+	posn, err := strconv.ParseInt(rec[posnIdx], 10, 64)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: bad POS %s", rec[chrIdx], rec[posnIdx])
+	}
+	alleles := []string{rec[refIdx]}
+	if rec[altIdx] != "." {
+		alleles = append(alleles, strings.Split(rec[altIdx], ",")...)
+	}
+	rlen := int64(len(rec[refIdx]))
+	info := make([]string, 0)
+	if rec[infoIdx] != "." && rec[infoIdx] != "" {
+		info = strings.Split(rec[infoIdx], ";")
+	}
+	for _, elem := range info {
+		if strings.HasPrefix(elem, "END=") {
+			if end, err := strconv.ParseInt(elem[4:], 10, 64); err == nil {
+				rlen = end - posn + 1
+			}
+		}
+	}
+	fmt_keys := make([]string, 0)
+	if len(rec) > fmtIdx && rec[fmtIdx] != "." {
+		fmt_keys = strings.Split(rec[fmtIdx], ":")
+	}
+	n_sample := 0
+	if len(rec) > firstGenoIdx {
+		n_sample = len(rec) - firstGenoIdx
+	}
+
+	shared := &encoder{}
+	shared.uint32(uint32(chrom))
+	shared.uint32(uint32(posn - 1))
+	shared.uint32(uint32(rlen))
+	if q, err := strconv.ParseFloat(rec[qualIdx], 32); err == nil {
+		shared.uint32(math.Float32bits(float32(q)))
+	} else {
+		shared.uint32(floatMissing)
+	}
+	shared.uint32(uint32(len(alleles))<<16 | uint32(len(info)))
+	shared.uint32(uint32(len(fmt_keys))<<24 | uint32(n_sample))
+	if rec[varIdx] == "." {
+		shared.typedString("")
+	} else {
+		shared.typedString(rec[varIdx])
+	}
+	for _, allele := range alleles {
+		shared.typedString(allele)
+	}
+	filters := make([]int64, 0)
+	if rec[filtIdx] != "." {
+		for _, f := range strings.Split(rec[filtIdx], ";") {
+			idx, ok := d.StringIdx[f]
+			if !ok {
+				return nil, nil, fmt.Errorf("FILTER %s not in header", f)
+			}
+			filters = append(filters, int64(idx))
+		}
+	}
+	if len(filters) == 0 {
+		shared.descriptor(typeNull, 0)
+	} else {
+		shared.ints(filters, true)
+	}
+	for _, elem := range info {
+		kv := strings.SplitN(elem, "=", 2)
+		idx, ok := d.StringIdx[kv[0]]
+		if _, declared := d.InfoTypes[kv[0]]; !ok || !declared {
+			return nil, nil, fmt.Errorf("INFO %s not in header", kv[0])
+		}
+		shared.ints([]int64{int64(idx)}, true)
+		if len(kv) == 1 || d.InfoTypes[kv[0]] == "Flag" {
+			shared.descriptor(typeNull, 0)
+			continue
+		}
+		switch d.InfoTypes[kv[0]] {
+		case "Integer":
+			shared.ints(parseInts(kv[1]), true)
+		case "Float":
+			vals := parseFloats(kv[1])
+			shared.descriptor(typeFloat, len(vals))
+			shared.floats(vals)
+		default:
+			shared.typedString(kv[1])
+		}
+	}
+
+	indiv := &encoder{}
+	for k, key := range fmt_keys {
+		idx, ok := d.StringIdx[key]
+		if _, declared := d.FormatTypes[key]; !ok || !declared {
+			return nil, nil, fmt.Errorf("FORMAT %s not in header", key)
+		}
+		indiv.ints([]int64{int64(idx)}, true)
+		values := make([]string, n_sample)
+		for j := range values {
+			g := strings.Split(rec[firstGenoIdx+j], ":")
+			values[j] = "."
+			if k < len(g) {
+				values[j] = g[k]
+			}
+		}
+		d.encodeFormat(indiv, key, values)
+	}
+	return shared.Bytes(), indiv.Bytes(), nil
+}
+
+//------------------------------------------------------------------------------
+// One FORMAT field for all samples: a descriptor then a fixed count of values
+// per sample, shorter vectors padded with end-of-vector values
+//------------------------------------------------------------------------------
+func (d *Dictionaries) encodeFormat(ec *encoder, key string, values []string) {
+	typ := d.FormatTypes[key]
+	switch {
+	case key == "GT" || typ == "Integer":
+		vecs := make([][]int64, len(values))
+		width := 1
+		all := make([]int64, 0)
+		for j, v := range values {
+			if key == "GT" {
+				vecs[j] = parseGenotype(v)
+			} else {
+				vecs[j] = parseInts(v)
+			}
+			if len(vecs[j]) > width {
+				width = len(vecs[j])
+			}
+			all = append(all, vecs[j]...)
+		}
+		vtype := intType(all)
+		ec.descriptor(vtype, width)
+		for _, vec := range vecs {
+			for len(vec) < width {
+				vec = append(vec, int32EOV)
+			}
+			ec.intValues(vec, vtype)
+		}
+	case typ == "Float":
+		vecs := make([][]uint32, len(values))
+		width := 1
+		for j, v := range values {
+			vecs[j] = parseFloats(v)
+			if len(vecs[j]) > width {
+				width = len(vecs[j])
+			}
+		}
+		ec.descriptor(typeFloat, width)
+		for _, vec := range vecs {
+			for len(vec) < width {
+				vec = append(vec, floatEOV)
+			}
+			ec.floats(vec)
+		}
+	default:
+		width := 1
+		for _, v := range values {
+			if len(v) > width {
+				width = len(v)
+			}
+		}
+		ec.descriptor(typeChar, width)
+		for _, v := range values {
+			ec.WriteString(v)
+			ec.Write(make([]byte, width-len(v)))
+		}
+	}
+}
+
+//-----------------------------------------------
+// Writer: BCF records to an (already compressing) writer
+//-----------------------------------------------
+type Writer struct {
+	w    io.Writer
+	Dict *Dictionaries
+}
+
+//------------------------------------------------------------------------------
+// Write the magic and header text, header lines are the ## meta lines
+// and the #CHROM line
+//------------------------------------------------------------------------------
+func NewWriter(w io.Writer, lines []string) (*Writer, error) {
+	meta := make([]string, 0, len(lines))
+	samples := []string{}
+	for _, line := range lines {
+		if strings.HasPrefix(line, "##") {
+			meta = append(meta, line)
+		} else if cols := strings.Split(line, "\t"); len(cols) > firstGenoIdx {
+			samples = cols[firstGenoIdx:]
+		}
+	}
+	text := strings.Join(lines, "\n") + "\n\x00"
+	out := append([]byte{}, Magic...)
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(text)))
+	out = append(out, text...)
+	if _, err := w.Write(out); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w, Dict: MakeDictionaries(meta, samples)}, nil
+}
+
+func (bw *Writer) Write(rec []string) error {
+	shared, indiv, err := bw.Dict.Encode(rec)
+	if err != nil {
+		return err
+	}
+	out := binary.LittleEndian.AppendUint32(nil, uint32(len(shared)))
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(indiv)))
+	out = append(append(out, shared...), indiv...)
+	_, err = bw.w.Write(out)
+	return err
+}
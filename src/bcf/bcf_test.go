@@ -0,0 +1,182 @@
+package bcf
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+var testMeta = []string{
+	"##fileformat=VCFv4.2",
+	"##FILTER=<ID=LowQual,Description=\"Low quality\">",
+	"##INFO=<ID=DB,Number=0,Type=Flag,Description=\"dbSNP\">",
+	"##INFO=<ID=SRC,Number=1,Type=String,Description=\"Source\">",
+	"##INFO=<ID=AF,Number=A,Type=Float,Description=\"Allele frequency\">",
+	"##INFO=<ID=AC,Number=A,Type=Integer,Description=\"Allele count\">",
+	"##FORMAT=<ID=GT,Number=1,Type=String,Description=\"Genotype\">",
+	"##FORMAT=<ID=DP,Number=1,Type=Integer,Description=\"Depth\">",
+	"##FORMAT=<ID=GP,Number=G,Type=Float,Description=\"Genotype probabilities\">",
+	"##FORMAT=<ID=FT,Number=1,Type=String,Description=\"Sample filter\">",
+	"##contig=<ID=1>",
+	"##contig=<ID=X>",
+}
+
+var testSamples = []string{"S1", "S2", "S3"}
+
+// A record from space separated fields
+func test_record(fields string) []string {
+	return strings.Split(fields, " ")
+}
+
+func round_trip(t *testing.T, d *Dictionaries, rec []string) []string {
+	t.Helper()
+	shared, indiv, err := d.Encode(rec)
+	if err != nil {
+		t.Fatalf("Encode %v: %v", rec, err)
+	}
+	out, err := d.Decode(shared, indiv)
+	if err != nil {
+		t.Fatalf("Decode %v: %v", rec, err)
+	}
+	return out
+}
+
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	d := MakeDictionaries(testMeta, testSamples)
+	tests := []struct {
+		name string
+		rec  string
+	}{
+		{"unphased GT", "1 100 rs1 A G 50 PASS . GT 0/0 0/1 1/1"},
+		{"phased GT", "1 100 rs1 A G 50 PASS . GT 0|1 1|0 1|1"},
+		{"mixed phase GT", "1 100 rs1 A G . PASS . GT 0|1 0/1 1/1"},
+		{"mixed ploidy GT", "X 2000 rs2 C T . PASS . GT 1 0/1 0"},
+		{"multi-allelic GT", "1 100 . A G,T . PASS . GT 1/2 2|2 0/1"},
+		{"missing GT", "1 100 rs1 A G . PASS . GT ./. .|. 0/1"},
+		{"missing values", "1 100 rs1 A G . . . GT:DP:GP ./.:.:. 0/1:12:0.1,0.8,0.1 1/1:.:0,0.2,0.8"},
+		{"missing vector elements", "1 100 rs1 A G,T . PASS AF=0.1,. GT:GP 0/1:0.1,.,0.9,0,0,0 ./.:. 0/0:."},
+		{"Flag and String INFO", "1 100 rs1 A G . LowQual DB;SRC=panel;AC=3,1 GT:FT 0/1:PASS 0/0:. 1/1:LowQual"},
+		{"sites only", "1 100 rs1 A G 12.5 PASS DB"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := test_record(tt.rec)
+			if got := round_trip(t, d, rec); strings.Join(got, " ") != tt.rec {
+				t.Errorf("round trip\n got %s\nwant %s", strings.Join(got, " "), tt.rec)
+			}
+		})
+	}
+}
+
+func TestEncodeEOVPadding(t *testing.T) {
+	d := MakeDictionaries(testMeta, testSamples)
+	_, indiv, err := d.Encode(test_record("X 2000 rs2 C T . PASS . GT:GP 1:0,1 0/1:0,1,0 .:."))
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	gt, gp := byte(d.StringIdx["GT"]), byte(d.StringIdx["GP"])
+	want := []byte{
+		// GT key, 2 int8 per sample: haploid 1 then EOV, 0/1, missing then EOV
+		0x11, gt, 0x21, 4, int8EOV & 0xff, 2, 4, 0, int8EOV & 0xff,
+		// GP key, 3 floats per sample
+		0x11, gp, 0x35,
+	}
+	if !bytes.Equal(indiv[:len(want)], want) {
+		t.Errorf("indiv % x, want prefix % x", indiv[:len(want)], want)
+	}
+	// the haploid GP of 2 values is padded with one float EOV
+	floats := indiv[len(want):]
+	if len(floats) != 9*4 {
+		t.Fatalf("%d bytes of GP, want %d", len(floats), 9*4)
+	}
+	if !bytes.Equal(floats[8:12], []byte{0x02, 0x00, 0x80, 0x7f}) {
+		t.Errorf("GP padding % x, want float EOV", floats[8:12])
+	}
+	rec := round_trip(t, d, test_record("X 2000 rs2 C T . PASS . GT:GP 1:0,1 0/1:0,1,0 .:."))
+	if got := strings.Join(rec[firstGenoIdx:], " "); got != "1:0,1 0/1:0,1,0 .:." {
+		t.Errorf("decoded samples %s", got)
+	}
+}
+
+func TestIntTypeWidths(t *testing.T) {
+	tests := []struct {
+		vals []int64
+		want int
+	}{
+		{[]int64{0, 1, 127}, typeInt8},
+		{[]int64{-120}, typeInt8},
+		{[]int64{int32Missing, int32EOV, 3}, typeInt8},
+		{[]int64{128}, typeInt16},
+		{[]int64{-121}, typeInt16},
+		{[]int64{5, 32767}, typeInt16},
+		{[]int64{-32760}, typeInt16},
+		{[]int64{32768}, typeInt32},
+		{[]int64{-32761, 1}, typeInt32},
+	}
+	for _, tt := range tests {
+		if got := intType(tt.vals); got != tt.want {
+			t.Errorf("intType(%v) = %d, want %d", tt.vals, got, tt.want)
+		}
+	}
+
+	// each width decodes back, missing values included
+	d := MakeDictionaries(testMeta, testSamples)
+	for _, dp := range []string{"3 . 127", "3 . 128", "-121 . 32767", "3 . 32768", "-40000 . 7"} {
+		rec := "1 100 rs1 A G . PASS . DP " + dp
+		if got := strings.Join(round_trip(t, d, test_record(rec)), " "); got != rec {
+			t.Errorf("round trip\n got %s\nwant %s", got, rec)
+		}
+	}
+}
+
+func TestEncodeUndeclared(t *testing.T) {
+	d := MakeDictionaries(testMeta, testSamples)
+	for _, rec := range []string{
+		"2 100 rs1 A G . PASS . GT 0/1 0/1 0/1",
+		"1 100 rs1 A G . Bad . GT 0/1 0/1 0/1",
+		"1 100 rs1 A G . PASS XX=1 GT 0/1 0/1 0/1",
+		"1 100 rs1 A G . PASS . GT:XX 0/1:1 0/1:1 0/1:1",
+	} {
+		if _, _, err := d.Encode(test_record(rec)); err == nil {
+			t.Errorf("Encode %s: no error", rec)
+		}
+	}
+}
+
+func TestWriterReader(t *testing.T) {
+	lines := append(append([]string(nil), testMeta...),
+		"#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\tFORMAT\t"+strings.Join(testSamples, "\t"))
+	records := []string{
+		"1 100 rs1 A G 50 PASS DB GT:GP 0|1:0,1,0 0/0:1,0,0 1/1:0,0,1",
+		"X 2000 rs2 C T . PASS SRC=panel GT 1 0/1 ./.",
+	}
+	var buf bytes.Buffer
+	bw, err := NewWriter(&buf, lines)
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	for _, rec := range records {
+		if err := bw.Write(test_record(rec)); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	br, err := NewReader(&buf)
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	if strings.Join(br.Samples, " ") != strings.Join(testSamples, " ") || len(br.Meta) != len(testMeta) {
+		t.Errorf("header: %d meta lines, samples %v", len(br.Meta), br.Samples)
+	}
+	for _, want := range records {
+		rec, err := br.Read()
+		if err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+		if got := strings.Join(rec, " "); got != want {
+			t.Errorf("read\n got %s\nwant %s", got, want)
+		}
+	}
+	if _, err := br.Read(); err == nil {
+		t.Errorf("Read past the last record: no error")
+	}
+}
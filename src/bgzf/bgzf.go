@@ -0,0 +1,265 @@
+// BGZF (blocked gzip) compression and decompression, as used by bgzip, tabix and BCF
+package bgzf
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// Uncompressed data held in one block, as bgzip uses
+const BlockSize = 0xff00
+
+// MaxBlockSize is the largest compressed block allowed
+const MaxBlockSize = 0x10000
+
+// The empty block that marks end of file
+var eofBlock = []byte{
+	0x1f, 0x8b, 0x08, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0xff, 0x06, 0x00, 0x42, 0x43,
+	0x02, 0x00, 0x1b, 0x00, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+}
+
+//-----------------------------------------------
+// Writer: compresses to BGZF blocks, keeping track of
+// the virtual offset of the next byte written
+//-----------------------------------------------
+type Writer struct {
+	w       io.Writer
+	buf     []byte
+	block   bytes.Buffer
+	fw      *flate.Writer
+	address uint64
+	closed  bool
+}
+
+func NewWriter(w io.Writer) *Writer {
+	fw, _ := flate.NewWriter(nil, flate.DefaultCompression)
+	return &Writer{w: w, buf: make([]byte, 0, BlockSize), fw: fw}
+}
+
+//------------------------------------------------------------------------------
+// Virtual offset: compressed block address << 16 | offset within the block
+//------------------------------------------------------------------------------
+func MakeVirtualOffset(address uint64, offset int) uint64 {
+	return address<<16 | uint64(offset)
+}
+
+func (bw *Writer) Tell() uint64 {
+	return MakeVirtualOffset(bw.address, len(bw.buf))
+}
+
+func (bw *Writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := BlockSize - len(bw.buf)
+		if n > len(p) {
+			n = len(p)
+		}
+		bw.buf = append(bw.buf, p[:n]...)
+		p = p[n:]
+		written += n
+		// a full block is written at once so Tell never points past a block end
+		if len(bw.buf) >= BlockSize {
+			if err := bw.Flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (bw *Writer) WriteString(s string) (int, error) {
+	return bw.Write([]byte(s))
+}
+
+//------------------------------------------------------------------------------
+// Compress and write out the current block (if any data is held)
+//------------------------------------------------------------------------------
+func (bw *Writer) Flush() error {
+	if len(bw.buf) == 0 {
+		return nil
+	}
+	bw.block.Reset()
+	bw.fw.Reset(&bw.block)
+	if _, err := bw.fw.Write(bw.buf); err != nil {
+		return err
+	}
+	if err := bw.fw.Close(); err != nil {
+		return err
+	}
+	size := 18 + bw.block.Len() + 8
+	header := []byte{0x1f, 0x8b, 0x08, 0x04, 0, 0, 0, 0, 0, 0xff, 0x06, 0x00, 0x42, 0x43, 0x02, 0x00, 0, 0}
+	binary.LittleEndian.PutUint16(header[16:], uint16(size-1))
+	footer := make([]byte, 8)
+	binary.LittleEndian.PutUint32(footer, crc32.ChecksumIEEE(bw.buf))
+	binary.LittleEndian.PutUint32(footer[4:], uint32(len(bw.buf)))
+	for _, part := range [][]byte{header, bw.block.Bytes(), footer} {
+		if _, err := bw.w.Write(part); err != nil {
+			return err
+		}
+	}
+	bw.address += uint64(size)
+	bw.buf = bw.buf[:0]
+	return nil
+}
+
+//------------------------------------------------------------------------------
+// Flush remaining data and write the EOF marker block, the underlying
+// writer is not closed
+//------------------------------------------------------------------------------
+func (bw *Writer) Close() error {
+	if bw.closed {
+		return nil
+	}
+	bw.closed = true
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	_, err := bw.w.Write(eofBlock)
+	bw.address += uint64(len(eofBlock))
+	return err
+}
+
+//-----------------------------------------------
+// Reader: decompresses BGZF a block at a time, so a position can be
+// given as a virtual offset and sought to
+//-----------------------------------------------
+type Reader struct {
+	r       io.ReadSeeker
+	fr      io.ReadCloser
+	header  []byte
+	data    []byte
+	block   []byte
+	offset  int
+	address uint64
+	next    uint64
+}
+
+func NewReader(r io.ReadSeeker) *Reader {
+	return &Reader{r: r, header: make([]byte, 18)}
+}
+
+//------------------------------------------------------------------------------
+// Read the block at the next address, io.EOF when there are no more blocks
+//------------------------------------------------------------------------------
+func (br *Reader) readBlock() error {
+	if _, err := br.r.Seek(int64(br.next), io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(br.r, br.header[:12]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return fmt.Errorf("truncated BGZF block at %d", br.next)
+		}
+		return err
+	}
+	if br.header[0] != 0x1f || br.header[1] != 0x8b || br.header[3]&0x04 == 0 {
+		return fmt.Errorf("not a BGZF block at %d", br.next)
+	}
+	xlen := int(binary.LittleEndian.Uint16(br.header[10:]))
+	extra := make([]byte, xlen)
+	if _, err := io.ReadFull(br.r, extra); err != nil {
+		return fmt.Errorf("truncated BGZF block at %d", br.next)
+	}
+	size := -1
+	for i := 0; i+4 <= len(extra); {
+		slen := int(binary.LittleEndian.Uint16(extra[i+2:]))
+		if extra[i] == 'B' && extra[i+1] == 'C' && slen == 2 {
+			size = int(binary.LittleEndian.Uint16(extra[i+4:])) + 1
+		}
+		i += 4 + slen
+	}
+	if size < 0 {
+		return fmt.Errorf("no BGZF block size at %d", br.next)
+	}
+	rest := size - 12 - xlen
+	if cap(br.data) < rest {
+		br.data = make([]byte, rest)
+	}
+	br.data = br.data[:rest]
+	if _, err := io.ReadFull(br.r, br.data); err != nil {
+		return fmt.Errorf("truncated BGZF block at %d", br.next)
+	}
+	isize := int(binary.LittleEndian.Uint32(br.data[rest-4:]))
+	if cap(br.block) < isize {
+		br.block = make([]byte, isize)
+	}
+	br.block = br.block[:isize]
+	if br.fr == nil {
+		br.fr = flate.NewReader(bytes.NewReader(br.data[:rest-8]))
+	} else {
+		br.fr.(flate.Resetter).Reset(bytes.NewReader(br.data[:rest-8]), nil)
+	}
+	if _, err := io.ReadFull(br.fr, br.block); err != nil {
+		return fmt.Errorf("bad BGZF block at %d: %v", br.next, err)
+	}
+	if crc32.ChecksumIEEE(br.block) != binary.LittleEndian.Uint32(br.data[rest-8:]) {
+		return fmt.Errorf("BGZF block checksum mismatch at %d", br.next)
+	}
+	br.address = br.next
+	br.next += uint64(size)
+	br.offset = 0
+	return nil
+}
+
+// fill makes data available, skipping empty blocks
+func (br *Reader) fill() error {
+	for br.offset >= len(br.block) {
+		if err := br.readBlock(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//------------------------------------------------------------------------------
+// Position at a virtual offset
+//------------------------------------------------------------------------------
+func (br *Reader) Seek(voffset uint64) error {
+	br.next = voffset >> 16
+	br.block = br.block[:0]
+	br.offset = 0
+	if err := br.fill(); err != nil {
+		return err
+	}
+	br.offset = int(voffset & 0xffff)
+	return nil
+}
+
+// Virtual offset of the next byte to be read
+func (br *Reader) Tell() uint64 {
+	if br.offset >= len(br.block) {
+		return MakeVirtualOffset(br.next, 0)
+	}
+	return MakeVirtualOffset(br.address, br.offset)
+}
+
+func (br *Reader) Read(p []byte) (int, error) {
+	if err := br.fill(); err != nil {
+		return 0, err
+	}
+	n := copy(p, br.block[br.offset:])
+	br.offset += n
+	return n, nil
+}
+
+//------------------------------------------------------------------------------
+// Read up to and including delim, without reading ahead (so Tell stays exact)
+//------------------------------------------------------------------------------
+func (br *Reader) ReadString(delim byte) (string, error) {
+	var line []byte
+	for {
+		if err := br.fill(); err != nil {
+			return string(line), err
+		}
+		if i := bytes.IndexByte(br.block[br.offset:], delim); i >= 0 {
+			line = append(line, br.block[br.offset:br.offset+i+1]...)
+			br.offset += i + 1
+			return string(line), nil
+		}
+		line = append(line, br.block[br.offset:]...)
+		br.offset = len(br.block)
+	}
+}
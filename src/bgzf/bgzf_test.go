@@ -0,0 +1,206 @@
+package bgzf
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// The BGZF compressed form of data, written in one call
+func compress(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	bw := NewWriter(&buf)
+	if _, err := bw.Write(data); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := bw.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// Compressed addresses and uncompressed sizes of the blocks of a BGZF
+// stream, in order
+func blocks(t *testing.T, data []byte) ([]uint64, []int) {
+	t.Helper()
+	addrs := make([]uint64, 0)
+	sizes := make([]int, 0)
+	addr := uint64(0)
+	for len(data) > 0 {
+		if len(data) < 18 {
+			t.Fatalf("%d bytes left, no block header", len(data))
+		}
+		size := int(binary.LittleEndian.Uint16(data[16:])) + 1
+		addrs = append(addrs, addr)
+		sizes = append(sizes, int(binary.LittleEndian.Uint32(data[size-4:])))
+		data = data[size:]
+		addr += uint64(size)
+	}
+	return addrs, sizes
+}
+
+// gzip readers take the blocks as gzip members
+func gunzip(t *testing.T, data []byte) []byte {
+	t.Helper()
+	gr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	out, err := io.ReadAll(gr)
+	if err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	return out
+}
+
+//-----------------------------------------------
+// boundaryFile: lines of one length that does not divide the block size,
+// so lines cross blocks, filling three blocks and part of a fourth, with
+// the virtual offset each line was written at
+//-----------------------------------------------
+type boundaryFile struct {
+	lines   []string
+	offsets []uint64
+	data    []byte
+	n       int
+}
+
+func write_boundary_file(t *testing.T) *boundaryFile {
+	t.Helper()
+	line_of := func(i int) string {
+		return fmt.Sprintf("1\t%09d\trs%09d\tA\tG\t.\tPASS\n", i, i)
+	}
+	bf := &boundaryFile{n: len(line_of(0))}
+	if BlockSize%bf.n == 0 {
+		t.Fatalf("line length %d divides the block size", bf.n)
+	}
+	for i := 0; len(bf.lines)*bf.n < 3*BlockSize+100; i++ {
+		bf.lines = append(bf.lines, line_of(i))
+	}
+	var buf bytes.Buffer
+	bw := NewWriter(&buf)
+	for _, line := range bf.lines {
+		bf.offsets = append(bf.offsets, bw.Tell())
+		if _, err := bw.WriteString(line); err != nil {
+			t.Fatalf("WriteString: %v", err)
+		}
+	}
+	if err := bw.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	bf.data = buf.Bytes()
+	return bf
+}
+
+// Does line i run over the end of a block
+func (bf *boundaryFile) crosses(i int) bool {
+	return i*bf.n/BlockSize != (i*bf.n+bf.n-1)/BlockSize
+}
+
+func TestWriter(t *testing.T) {
+	data := []byte("##fileformat=VCFv4.2\n1\t100\trs1\tA\tG\n")
+	compressed := compress(t, data)
+	if !bytes.HasSuffix(compressed, eofBlock) {
+		t.Errorf("no EOF block at the end")
+	}
+	if got := gunzip(t, compressed); !bytes.Equal(got, data) {
+		t.Errorf("read back %q, want %q", got, data)
+	}
+}
+
+func TestWriterEmpty(t *testing.T) {
+	if compressed := compress(t, nil); !bytes.Equal(compressed, eofBlock) {
+		t.Errorf("empty stream % x, want the EOF block alone", compressed)
+	}
+}
+
+func TestWriterBlocks(t *testing.T) {
+	bf := write_boundary_file(t)
+	total := len(bf.lines) * bf.n
+	addrs, sizes := blocks(t, bf.data)
+	want := []int{BlockSize, BlockSize, BlockSize, total - 3*BlockSize, 0}
+	if fmt.Sprint(sizes) != fmt.Sprint(want) {
+		t.Fatalf("block sizes %v, want %v", sizes, want)
+	}
+	for i := 0; i+1 < len(sizes); i++ {
+		if sizes[i] > MaxBlockSize {
+			t.Errorf("block %d holds %d bytes, more than 64 KiB", i, sizes[i])
+		}
+	}
+	// a virtual offset is the block address and the offset within it
+	for i, offset := range bf.offsets {
+		want := MakeVirtualOffset(addrs[i*bf.n/BlockSize], i*bf.n%BlockSize)
+		if offset != want {
+			t.Fatalf("line %d written at %x, want %x", i, offset, want)
+		}
+	}
+	if got := gunzip(t, bf.data); string(got) != strings.Join(bf.lines, "") {
+		t.Errorf("read back %d bytes, want %d", len(got), total)
+	}
+}
+
+func TestReader(t *testing.T) {
+	data := []byte("##fileformat=VCFv4.2\n1\t100\trs1\tA\tG\n")
+	got, err := io.ReadAll(NewReader(bytes.NewReader(compress(t, data))))
+	if err != nil {
+		t.Fatalf("read back: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("read back %q, want %q", got, data)
+	}
+	br := NewReader(bytes.NewReader(compress(t, nil)))
+	if n, err := br.Read(make([]byte, 10)); n != 0 || err != io.EOF {
+		t.Errorf("empty stream: Read = %d, %v, want io.EOF", n, err)
+	}
+}
+
+func TestReaderSeek(t *testing.T) {
+	bf := write_boundary_file(t)
+	br := NewReader(bytes.NewReader(bf.data))
+	for i, line := range bf.lines {
+		if tell := br.Tell(); tell != bf.offsets[i] {
+			t.Fatalf("line %d at %x, written at %x", i, tell, bf.offsets[i])
+		}
+		got, err := br.ReadString('\n')
+		if err != nil || got != line {
+			t.Fatalf("line %d: %q, %v, want %q", i, got, err, line)
+		}
+	}
+	if _, err := br.ReadString('\n'); err != io.EOF {
+		t.Errorf("past the last line: %v, want io.EOF", err)
+	}
+
+	// seek to the lines either side of each block boundary
+	for i, line := range bf.lines {
+		if i > 0 && !bf.crosses(i) && !bf.crosses(i-1) {
+			continue
+		}
+		if err := br.Seek(bf.offsets[i]); err != nil {
+			t.Fatalf("Seek %x: %v", bf.offsets[i], err)
+		}
+		if got, err := br.ReadString('\n'); err != nil || got != line {
+			t.Errorf("line %d after Seek: %q, %v, want %q", i, got, err, line)
+		}
+	}
+}
+
+func TestReaderBadBlock(t *testing.T) {
+	compressed := compress(t, []byte(strings.Repeat("1\t100\trs1\tA\tG\n", 10)))
+	truncated := compressed[:len(compressed)-len(eofBlock)-5]
+	if _, err := io.ReadAll(NewReader(bytes.NewReader(truncated))); err == nil {
+		t.Errorf("truncated block: no error")
+	}
+	corrupt := append([]byte(nil), compressed...)
+	corrupt[len(corrupt)-len(eofBlock)-8] ^= 0xff
+	if _, err := io.ReadAll(NewReader(bytes.NewReader(corrupt))); err == nil {
+		t.Errorf("bad checksum: no error")
+	}
+	if _, err := io.ReadAll(NewReader(strings.NewReader("not bgzf at all, no"))); err == nil {
+		t.Errorf("plain text: no error")
+	}
+}
@@ -0,0 +1,322 @@
+// Run configuration: a JSON config file describing a whole merge run, and the
+// legacy key=value template and parameter files
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+//-----------------------------------------------
+// Config: a merge run, values not given are left to the command line flags
+// (and their defaults)
+//-----------------------------------------------
+type Config struct {
+	Inputs     []Input  `json:"inputs"`
+	VcfPrefix  string   `json:"vcfprfx"`
+	Chrom      string   `json:"chr"`
+	Contigs    string   `json:"contigs"`
+	Regions    []string `json:"regions"`
+	Bed        string   `json:"bed"`
+	Threshold  *float64 `json:"threshold"`
+	Resolver   string   `json:"resolver"`
+	Palindrome string   `json:"palindrome"`
+	QC         QC       `json:"qc"`
+	Output     Output   `json:"output"`
+	Samples    Samples  `json:"samples"`
+}
+
+//-----------------------------------------------
+// Input: an assay, its file (a template as in the legacy template file) and
+// manifest metadata
+//-----------------------------------------------
+type Input struct {
+	Assay    string `json:"assay"`
+	Path     string `json:"path"`
+	Abbrev   string `json:"abbrev"`
+	Priority int    `json:"priority"`
+	Build    string `json:"build"`
+	Panel    string `json:"panel"`
+	QC       QC     `json:"qc"`
+}
+
+//-----------------------------------------------
+// QC: thresholds, as the legacy parameter file
+//-----------------------------------------------
+type QC struct {
+	TestNum   *int     `json:"testnum"`
+	CallRate  *float64 `json:"callrate"`
+	MafDelta  *float64 `json:"mafdelta"`
+	InfoScore *float64 `json:"infoscore"`
+}
+
+type Output struct {
+	Path        string   `json:"path"`
+	Log         string   `json:"log"`
+	Rejections  string   `json:"rejections"`
+	Concordance string   `json:"concordance"`
+	Identity    string   `json:"identity"`
+	IbsMin      *float64 `json:"ibsmin"`
+}
+
+//-----------------------------------------------
+// Samples: sample maps and lists, Rename is a rename file per assay
+//-----------------------------------------------
+type Samples struct {
+	Rename    map[string]string `json:"rename"`
+	Keep      string            `json:"keep"`
+	Remove    string            `json:"remove"`
+	Order     string            `json:"order"`
+	OrderFile string            `json:"orderfile"`
+	Unlisted  string            `json:"unlisted"`
+	Collide   string            `json:"collide"`
+	SexFile   string            `json:"sexfile"`
+	Par       string            `json:"par"`
+}
+
+//------------------------------------------------------------------------------
+// Load a config file: malformed JSON, unknown keys and values of the wrong
+// type are errors given as path:line
+//------------------------------------------------------------------------------
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	w := &walker{dec: json.NewDecoder(bytes.NewReader(data)), data: data}
+	if err := w.value(reflect.TypeOf(Config{}), "config"); err != nil {
+		return nil, fmt.Errorf("%s:%v", path, err)
+	}
+	if _, err := w.dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("%s:%d: data after the config object", path, w.line())
+	}
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		if te, ok := err.(*json.UnmarshalTypeError); ok {
+			return nil, fmt.Errorf("%s:%d: %s: expected %v, got %s", path, line_at(data, te.Offset),
+				te.Field, te.Type, te.Value)
+		}
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	seen := make(map[string]bool)
+	for i, in := range cfg.Inputs {
+		switch {
+		case in.Assay == "":
+			return nil, fmt.Errorf("%s: inputs[%d]: no assay", path, i)
+		case in.Path == "":
+			return nil, fmt.Errorf("%s: inputs[%d]: no path for %s", path, i, in.Assay)
+		case seen[in.Assay]:
+			return nil, fmt.Errorf("%s: inputs[%d]: assay %s given twice", path, i, in.Assay)
+		case in.Priority < 0:
+			return nil, fmt.Errorf("%s: inputs[%d]: priority %d is not positive", path, i, in.Priority)
+		}
+		seen[in.Assay] = true
+	}
+	return &cfg, nil
+}
+
+//-----------------------------------------------
+// walker: checks the keys of the JSON objects against the Config fields,
+// keeping track of the position for errors
+//-----------------------------------------------
+type walker struct {
+	dec  *json.Decoder
+	data []byte
+}
+
+func (w *walker) line() int {
+	return line_at(w.data, w.dec.InputOffset())
+}
+
+func line_at(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+func (w *walker) token() (json.Token, error) {
+	tok, err := w.dec.Token()
+	if se, ok := err.(*json.SyntaxError); ok {
+		return nil, fmt.Errorf("%d: %v", line_at(w.data, se.Offset), se)
+	}
+	if err == io.EOF {
+		return nil, fmt.Errorf("%d: unexpected end of file", w.line())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%d: %v", w.line(), err)
+	}
+	return tok, nil
+}
+
+func (w *walker) value(t reflect.Type, path string) error {
+	tok, err := w.token()
+	if err != nil {
+		return err
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch tok {
+	case json.Delim('{'):
+		if t.Kind() != reflect.Struct && t.Kind() != reflect.Map {
+			return fmt.Errorf("%d: %s: expected %v, got an object", w.line(), path, t)
+		}
+		for w.dec.More() {
+			ktok, err := w.token()
+			if err != nil {
+				return err
+			}
+			key := ktok.(string)
+			et := t
+			if t.Kind() == reflect.Map {
+				et = t.Elem()
+			} else if f, ok := field_by_key(t, key); ok {
+				et = f.Type
+			} else {
+				return fmt.Errorf("%d: unknown key %q in %s, expected one of %s", w.line(), key, path,
+					strings.Join(keys(t), ", "))
+			}
+			if err := w.value(et, path+"."+key); err != nil {
+				return err
+			}
+		}
+		_, err = w.token()
+		return err
+	case json.Delim('['):
+		if t.Kind() != reflect.Slice {
+			return fmt.Errorf("%d: %s: expected %v, got a list", w.line(), path, t)
+		}
+		for i := 0; w.dec.More(); i++ {
+			if err := w.value(t.Elem(), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		_, err = w.token()
+		return err
+	}
+	return nil
+}
+
+func field_by_key(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		if strings.Split(t.Field(i).Tag.Get("json"), ",")[0] == key {
+			return t.Field(i), true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+func keys(t reflect.Type) []string {
+	names := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		names = append(names, strings.Split(t.Field(i).Tag.Get("json"), ",")[0])
+	}
+	return names
+}
+
+//------------------------------------------------------------------------------
+// QC thresholds by parameter file key, thresholds not given are left out
+//------------------------------------------------------------------------------
+func (qc QC) Values() map[string]string {
+	values := make(map[string]string)
+	if qc.TestNum != nil {
+		values[TestNum] = strconv.Itoa(*qc.TestNum)
+	}
+	for key, value := range map[string]*float64{CallRate: qc.CallRate, MafDelta: qc.MafDelta, InfoScore: qc.InfoScore} {
+		if value != nil {
+			values[key] = strconv.FormatFloat(*value, 'g', -1, 64)
+		}
+	}
+	return values
+}
+
+// Parameter file keys
+const (
+	TestNum   = "TESTNUM"
+	CallRate  = "CALLRATE"
+	MafDelta  = "MAFDELTA"
+	InfoScore = "INFOSCORE"
+)
+
+var paramKeys = []string{TestNum, CallRate, MafDelta, InfoScore}
+
+//------------------------------------------------------------------------------
+// Read a legacy template file: assay=path lines, in order
+//------------------------------------------------------------------------------
+func ReadTemplate(path string) ([]string, map[string]string, error) {
+	assays := make([]string, 0)
+	paths := make(map[string]string)
+	err := read_key_values(path, func(key string, value string) error {
+		if _, ok := paths[key]; ok {
+			return fmt.Errorf("assay %s given twice", key)
+		}
+		if value == "" {
+			return fmt.Errorf("no path for assay %s", key)
+		}
+		assays = append(assays, key)
+		paths[key] = value
+		return nil
+	})
+	return assays, paths, err
+}
+
+//------------------------------------------------------------------------------
+// Read a legacy parameter file: KEY=value lines for the QC thresholds,
+// TESTNUM an integer and the others numbers
+//------------------------------------------------------------------------------
+func ReadParams(path string) (map[string]string, error) {
+	params := make(map[string]string)
+	err := read_key_values(path, func(key string, value string) error {
+		known := false
+		for _, pk := range paramKeys {
+			known = known || key == pk
+		}
+		if !known {
+			return fmt.Errorf("unknown parameter %s, expected one of %s", key, strings.Join(paramKeys, ", "))
+		}
+		if key == TestNum {
+			if _, err := strconv.Atoi(value); err != nil {
+				return fmt.Errorf("%s value %q is not an integer", key, value)
+			}
+		} else if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return fmt.Errorf("%s value %q is not a number", key, value)
+		}
+		params[key] = value
+		return nil
+	})
+	return params, err
+}
+
+// key=value lines (the value may itself hold "="), blank and # lines skipped
+func read_key_values(path string, parse func(string, string) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.SplitN(text, "=", 2)
+		if len(fields) != 2 || strings.TrimSpace(fields[0]) == "" {
+			return fmt.Errorf("%s:%d: expected key=value, got %q", path, lineno, text)
+		}
+		if err := parse(strings.TrimSpace(fields[0]), strings.TrimSpace(fields[1])); err != nil {
+			return fmt.Errorf("%s:%d: %v", path, lineno, err)
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,129 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// A file of the text given in a test directory
+func test_file(t *testing.T, name string, text string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(text), 0666); err != nil {
+		t.Fatalf("write %s: %v", name, err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	path := test_file(t, "run.json", `{
+  "inputs": [
+    {"assay": "affy", "path": "affy.vcf.gz", "priority": 1, "qc": {"testnum": 50}},
+    {"assay": "illumina", "path": "illumina.vcf.gz"}
+  ],
+  "chr": "22",
+  "threshold": 0.8,
+  "qc": {"callrate": 0.95, "testnum": 100},
+  "output": {"path": "out.vcf.gz", "ibsmin": 0.85}
+}
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(cfg.Inputs) != 2 || cfg.Inputs[1].Path != "illumina.vcf.gz" || *cfg.Inputs[0].QC.TestNum != 50 {
+		t.Errorf("inputs %+v", cfg.Inputs)
+	}
+	if cfg.Chrom != "22" || *cfg.Threshold != 0.8 || *cfg.Output.IbsMin != 0.85 || cfg.Resolver != "" {
+		t.Errorf("config %+v", cfg)
+	}
+	values := cfg.QC.Values()
+	if len(values) != 2 || values[TestNum] != "100" || values[CallRate] != "0.95" {
+		t.Errorf("QC values %v", values)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"syntax", "{\n  \"chr\": \"22\",\n  \"bed\" \"x.bed\"\n}\n", ":3:"},
+		{"unknown key", "{\n  \"chr\": \"22\",\n  \"output\": {\n    \"file\": \"x\"\n  }\n}\n", ":4: unknown key \"file\""},
+		{"wrong type", "{\n  \"chr\": \"22\",\n  \"threshold\": \"high\"\n}\n", ":3:"},
+		{"integer", "{\n  \"qc\": {\n    \"testnum\": 2.5\n  }\n}\n", ":3:"},
+		{"list", "{\n  \"regions\": \"1:1-100\"\n}\n", ":2:"},
+		{"object", "{\n  \"inputs\": [\n    \"affy\"\n  ]\n}\n", ":3:"},
+		{"end of file", "{\n  \"chr\": \"22\",\n", ":3: unexpected end"},
+		{"trailing data", "{}\n{}\n", ":2: data after the config object"},
+		{"no path", `{"inputs": [{"assay": "affy"}]}`, "inputs[0]: no path for affy"},
+		{"assay twice", `{"inputs": [{"assay": "a", "path": "x"}, {"assay": "a", "path": "y"}]}`, "inputs[1]: assay a given twice"},
+	}
+	for _, tt := range tests {
+		path := test_file(t, "run.json", tt.text)
+		_, err := Load(path)
+		if err == nil {
+			t.Errorf("%s: no error", tt.name)
+			continue
+		}
+		if !strings.HasPrefix(err.Error(), path) || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error %q, want %s%s", tt.name, err, path, tt.want)
+		}
+	}
+}
+
+func TestReadTemplate(t *testing.T) {
+	path := test_file(t, "tplt.txt", "# assays\naffy=%s/affy.vcf\n\nillumina = %s/ill=1.vcf\n")
+	assays, paths, err := ReadTemplate(path)
+	if err != nil {
+		t.Fatalf("ReadTemplate: %v", err)
+	}
+	if strings.Join(assays, ",") != "affy,illumina" || paths["illumina"] != "%s/ill=1.vcf" {
+		t.Errorf("assays %v, paths %v", assays, paths)
+	}
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"affy=a.vcf\naffy\n", ":2: expected key=value"},
+		{"affy=a.vcf\n\naffy=b.vcf\n", ":3: assay affy given twice"},
+		{"affy=\n", ":1: no path for assay affy"},
+		{"=a.vcf\n", ":1: expected key=value"},
+	}
+	for _, tt := range tests {
+		path := test_file(t, "tplt.txt", tt.text)
+		if _, _, err := ReadTemplate(path); err == nil || !strings.Contains(err.Error(), path+tt.want) {
+			t.Errorf("template %q: error %v, want %s%s", tt.text, err, path, tt.want)
+		}
+	}
+}
+
+func TestReadParams(t *testing.T) {
+	path := test_file(t, "params.txt", "TESTNUM=100\n# thresholds\nCALLRATE=0.95\nMAFDELTA = 0.1\n")
+	params, err := ReadParams(path)
+	if err != nil {
+		t.Fatalf("ReadParams: %v", err)
+	}
+	if len(params) != 3 || params[TestNum] != "100" || params[MafDelta] != "0.1" {
+		t.Errorf("params %v", params)
+	}
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"CALLRATE=0.95\nTESTNUM=100.5\n", ":2: TESTNUM value \"100.5\" is not an integer"},
+		{"TESTNUM=1e2\n", ":1: TESTNUM value \"1e2\" is not an integer"},
+		{"\nINFOSCORE=high\n", ":2: INFOSCORE value \"high\" is not a number"},
+		{"CALLRATE=0.95\nMINAF=0.01\n", ":2: unknown parameter MINAF"},
+		{"TESTNUM 100\n", ":1: expected key=value"},
+	}
+	for _, tt := range tests {
+		path := test_file(t, "params.txt", tt.text)
+		if _, err := ReadParams(path); err == nil || !strings.Contains(err.Error(), path+tt.want) {
+			t.Errorf("params %q: error %v, want %s%s", tt.text, err, path, tt.want)
+		}
+	}
+}
@@ -10,41 +10,75 @@ package main
 // 2) For minimum-key records sets record combination takes place, if set-size > 1
 //
 // args:
+//  --config: JSON config file for the run, flags given override it
 //  --tpltfile: a text file of template file paths for files to be merged
 //  --paramfile: file of parameters for genotype resolution
-//  --chr: chromosome
+//  --manifest: assay manifest (TSV), listing every assay
+//  --chr: chromosome, "all" for every chromosome
+//  --contigs: contig order, a file or comma separated list of names
+//  --region, --bed: regions to merge
 //  --logfile: full filepath for logging
+//  --rejfile: allele rejection report (TSV)
+//  --concfile: per-sample concordance report (TSV)
+//  --ibsfile, --ibsmin: sample identity report (TSV) and its IBS threshold
+//  --out: output file, .gz/.bgz or .bcf for indexed output, stdout if not given
 //  --vcfprfx: directory root for vcf files
+//  --resolver: genotype resolution for samples in more than one assay
+//  --palindrome: palindromic alleles, drop or af
+//  --rename, --keep, --remove: sample renaming and selection
+//  --collide, --sample-order, --orderfile, --unlisted: output sample columns
+//  --sexfile, --par: sample sex and pseudoautosomal regions for haploid calls
 //
 import (
-	"bufio"
-	"compress/gzip"
+	"assay"
+	"config"
+	"context"
 	"flag"
 	"fmt"
-	"genometrics"
-	"io"
 	"log"
+	"merger"
 	"os"
+	"os/signal"
+	"ploidy"
+	"region"
 	"sample"
 	"sort"
+	"strconv"
 	"strings"
-	"variant"
+	"vcfio"
 	"vcfmerge"
 )
 
-const max_posn int64 = 9999999999
-
-var empty_record = []string{}
-
 //-----------------------------------------------
 // global vars, accessed by multiple funcs
 //-----------------------------------------------
 var tpltFilePath string
 var paramFilePath string
 var logFilePath string
+var rejFilePath string
+var concFilePath string
+var ibsFilePath string
+var renameSpecs stringList
+var keepFilePath string
+var removeFilePath string
+var collideMode string
+var palindromeMode string
+var sampleOrder string
+var orderFilePath string
+var unlistedMode string
+var ibsMin float64
+var outFilePath string
 var vcfPathPref string
 var chr string
+var contigList string
+var regionSpecs stringList
+var bedFilePath string
+var resolverName string
 var threshold float64
+var manifestPath string
+var configPath string
+var sexFilePath string
+var parSpec string
 
 //-----------------------------------------------
 // main package routines
@@ -57,28 +91,104 @@ func init() {
 		pusage               = "QC Parameter file"
 		defaultLogFilePath   = "./data/filemergevcf_output.log"
 		lusage               = "Log file"
+		defaultRejFilePath   = ""
+		rusage               = "Allele rejection report file, not written if not given"
+		defaultConcFilePath  = ""
+		concusage            = "Per-sample cross-assay concordance report file, not written if not given"
+		renameusage          = "assay=file of old and new sample IDs, may be repeated"
+		defaultKeepFilePath  = ""
+		keepusage            = "file of sample IDs to keep"
+		defaultRmFilePath    = ""
+		removeusage          = "file of sample IDs to remove"
+		defaultCollideMode   = merger.CollideMerge
+		collideusage         = "samples in more than one assay: merge (resolve to one column) or suffix (a column per assay)"
+		defaultPalindrome    = merger.PalindromeDrop
+		palindromeusage      = "palindromic alleles against their reverse (A/T and T/A): drop, or af (resolve by allele frequency)"
+		defaultSampleOrder   = merger.OrderAlpha
+		orderusage           = "output sample order: alpha, assay (first seen in template order) or file (--orderfile)"
+		defaultOrderFilePath = ""
+		orderfileusage       = "file of sample IDs in output order, for --sample-order file"
+		defaultUnlistedMode  = merger.UnlistedAppend
+		unlistedusage        = "samples not in the order file: append or drop"
+		defaultIbsFilePath   = ""
+		ibsusage             = "Sample identity (IBS) report file, enables the sample swap/duplicate check"
+		defaultIbsMin        = 0.9
+		ibsminusage          = "IBS at which two samples are taken to be the same individual"
+		defaultOutFilePath   = ""
+		ousage               = "Output file, BGZF compressed and indexed if .gz or .bgz (default stdout)"
 		defaultvcfPathPref   = "/var/data"
 		vusage               = "default path prefix for vcf files"
 		defaultThreshold     = 0.9
 		thrusage             = "Prob threshold"
 		defaultChr           = "22"
-		chrusage             = "default chromosome (number as string)"
+		chrusage             = "default chromosome (number as string), \"all\" for whole genome"
+		defaultContigList    = ""
+		cusage               = "contig order (file of names or comma separated list), default from headers"
+		regusage             = "region chr:start-end to merge, may be repeated"
+		defaultBedFilePath   = ""
+		bedusage             = "BED file of regions to merge"
+		defaultResolverName  = vcfmerge.ResolvePosterior
+		defaultSexFilePath   = ""
+		sexusage             = "sample sex file (sample and M/F), enables haploid calling on X/Y/MT"
+		defaultParSpec       = ""
+		parusage             = "pseudoautosomal regions, grch37, grch38 or chr:start-end list (default from the manifest build, or grch37)"
+		defaultConfigPath    = ""
+		configusage          = "JSON config file for the run, command line flags override it"
+		defaultManifestPath  = "./data/assays.tsv"
+		manifestusage        = "assay manifest: abbreviation, priority, build, panel and QC thresholds, listing every assay"
 	)
+	resusage := "genotype resolution for overlapping samples: " + strings.Join(vcfmerge.ResolverNames, ", ")
 	flag.StringVar(&tpltFilePath, "tpltfile", defaultTpltFilePath, tusage)
 	flag.StringVar(&tpltFilePath, "t", defaultTpltFilePath, tusage+" (shorthand)")
 	flag.StringVar(&paramFilePath, "paramfile", defaultParamFilePath, pusage)
 	flag.StringVar(&paramFilePath, "p", defaultParamFilePath, pusage+" (shorthand)")
 	flag.StringVar(&logFilePath, "logfile", defaultLogFilePath, lusage)
 	flag.StringVar(&logFilePath, "l", defaultLogFilePath, lusage+" (shorthand)")
+	flag.StringVar(&rejFilePath, "rejfile", defaultRejFilePath, rusage)
+	flag.StringVar(&concFilePath, "concfile", defaultConcFilePath, concusage)
+	flag.Var(&renameSpecs, "rename", renameusage)
+	flag.StringVar(&keepFilePath, "keep", defaultKeepFilePath, keepusage)
+	flag.StringVar(&removeFilePath, "remove", defaultRmFilePath, removeusage)
+	flag.StringVar(&collideMode, "collide", defaultCollideMode, collideusage)
+	flag.StringVar(&palindromeMode, "palindrome", defaultPalindrome, palindromeusage)
+	flag.StringVar(&sampleOrder, "sample-order", defaultSampleOrder, orderusage)
+	flag.StringVar(&orderFilePath, "orderfile", defaultOrderFilePath, orderfileusage)
+	flag.StringVar(&unlistedMode, "unlisted", defaultUnlistedMode, unlistedusage)
+	flag.StringVar(&ibsFilePath, "ibsfile", defaultIbsFilePath, ibsusage)
+	flag.Float64Var(&ibsMin, "ibsmin", defaultIbsMin, ibsminusage)
+	flag.StringVar(&outFilePath, "out", defaultOutFilePath, ousage)
+	flag.StringVar(&outFilePath, "o", defaultOutFilePath, ousage+" (shorthand)")
 	flag.StringVar(&vcfPathPref, "vcfprfx", defaultvcfPathPref, vusage)
 	flag.StringVar(&vcfPathPref, "v", defaultvcfPathPref, vusage+" (shorthand)")
 	flag.Float64Var(&threshold, "threshold", defaultThreshold, thrusage)
 	flag.Float64Var(&threshold, "h", defaultThreshold, thrusage+" (shorthand)")
 	flag.StringVar(&chr, "chr", defaultChr, chrusage)
 	flag.StringVar(&chr, "c", defaultChr, chrusage+" (shorthand)")
+	flag.StringVar(&contigList, "contigs", defaultContigList, cusage)
+	flag.Var(&regionSpecs, "region", regusage)
+	flag.StringVar(&bedFilePath, "bed", defaultBedFilePath, bedusage)
+	flag.StringVar(&resolverName, "resolver", defaultResolverName, resusage)
+	flag.StringVar(&sexFilePath, "sexfile", defaultSexFilePath, sexusage)
+	flag.StringVar(&parSpec, "par", defaultParSpec, parusage)
+	flag.StringVar(&manifestPath, "manifest", defaultManifestPath, manifestusage)
+	flag.StringVar(&configPath, "config", defaultConfigPath, configusage)
 	flag.Parse()
 }
 
+//-----------------------------------------------
+// stringList: a flag that may be given more than once
+//-----------------------------------------------
+type stringList []string
+
+func (sl *stringList) String() string {
+	return strings.Join(*sl, ",")
+}
+
+func (sl *stringList) Set(value string) error {
+	*sl = append(*sl, value)
+	return nil
+}
+
 func check(e error) {
 	if e != nil {
 		log.Println("err != nil")
@@ -86,7 +196,12 @@ func check(e error) {
 	}
 }
 
+//-------------------------------------------------------------
+// Read the run's flags, config and side files into merger options, run
+// the merge and log its metrics
+//-------------------------------------------------------------
 func main() {
+	cfg := load_config()
 	// set up logging to a file
 	lf, err := os.OpenFile(logFilePath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
 	check(err)
@@ -95,230 +210,333 @@ func main() {
 	log.SetOutput(lf)
 	log.Printf("START merge %s, %s\n", paramFilePath, tpltFilePath)
 
-	// Load file templates
-	f, err := os.Open(tpltFilePath)
+	// Load file templates, from the config inputs unless --tpltfile is given
+	assaytype_list, assaytype_filename := get_inputs(cfg)
+	assays := get_manifest(cfg)
+	opts := merger.Options{Chrom: chr, Contigs: get_contigs(contigList), Regions: get_regions(regionSpecs, bedFilePath),
+		Threshold: threshold, Resolver: resolverName, Params: get_params(cfg), Manifest: assays, Collide: collideMode,
+		Palindrome: palindromeMode, SampleOrder: sampleOrder, Unlisted: unlistedMode, HeaderLines: []string{"##commandline=" + strings.Join(os.Args, " ")},
+		IbsMin: ibsMin, Log: log.New(lf, "", log.LstdFlags)}
+	opts.Rename, opts.Keep, opts.Remove = load_sample_selection()
+	if sampleOrder == merger.OrderFile {
+		if orderFilePath == "" {
+			log.Fatalf("--sample-order %s needs --orderfile\n", sampleOrder)
+		}
+		opts.Order, err = sample.ReadOrderFile(orderFilePath)
+		check(err)
+	}
+	build, err := merger.Build(assays, assaytype_list)
 	check(err)
-	defer f.Close()
-	scanner := bufio.NewScanner(f)
-	assaytype_filename := make(map[string]string)
-	assaytype_list := make([]string, 0)
-	fscanners := make(map[string]*bufio.Scanner)
-	freaders := make(map[string]*bufio.Reader)
-
-	for scanner.Scan() {
-		text := scanner.Text()
-		if !strings.HasPrefix(text, "#") {
-			fields := strings.Split(text, "=")
-			assaytype_filename[fields[0]] = fmt.Sprintf(fields[1], vcfPathPref, chr)
-			assaytype_list = append(assaytype_list, fields[0])
+	if parSpec == "" {
+		parSpec = "grch37"
+		if ploidy.HasBuild(build) {
+			parSpec = build
 		}
 	}
-	// Load QC parameters (if present)
-	fp, err := os.Open(paramFilePath)
-	//check(err)
-	defer fp.Close()
-	testnum := ""
-	callrate := ""
-	mafdelta := ""
-	infoscore := ""
-	scanner = bufio.NewScanner(fp)
-	for scanner.Scan() {
-		text := scanner.Text()
-		if !strings.HasPrefix(text, "#") {
-			fields := strings.Split(text, "=")
-			if fields[0] == "TESTNUM" {
-				testnum = fields[1]
-			}
-			if fields[0] == "CALLRATE" {
-				callrate = fields[1]
-			}
-			if fields[0] == "MAFDELTA" {
-				mafdelta = fields[1]
-			}
-			if fields[0] == "INFOSCORE" {
-				infoscore = fields[1]
-			}
+	if sexFilePath != "" {
+		opts.Ploidy, err = ploidy.NewModel(sexFilePath, parSpec)
+		check(err)
+		log.Printf("Sex file: %s, PAR: %s\n", sexFilePath, parSpec)
+	}
+	if rejFilePath != "" {
+		f, err := os.Create(rejFilePath)
+		check(err)
+		defer f.Close()
+		opts.Rejections = f
+	}
+	if concFilePath != "" {
+		f, err := os.Create(concFilePath)
+		check(err)
+		defer f.Close()
+		opts.Concordance = f
+	}
+	if ibsFilePath != "" {
+		f, err := os.Create(ibsFilePath)
+		check(err)
+		defer f.Close()
+		opts.Identity = f
+	}
+	sources := make([]merger.Source, 0, len(assaytype_list))
+	for _, at := range assaytype_list {
+		sources = append(sources, merger.Source{Assay: at, Path: assaytype_filename[at]})
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	vcfWriter, err := vcfio.Create(outFilePath)
+	check(err)
+	metrics, err := merge(ctx, sources, opts, vcfWriter)
+	if err != nil {
+		remove_output(outFilePath)
+	}
+	check(err)
+	log.Printf("Rejection report: %v\n", metrics.Rejections)
+	log.Printf("Header conflicts: %d\n", len(metrics.HeaderConflicts))
+	log.Printf("EXIT,wrt=%d,allgeno=%d,2ol=%d,gt2ol=%d,mmc=%d,misstested=%d,missing=%d\n", metrics.Records, metrics.AllGenoCount, metrics.TwoOverlapCount, metrics.GtTwoOverlapCount, metrics.MismatchCount, metrics.MissTestCount, metrics.MissingCount)
+	log.Printf("Phase: compared=%d, differs=%d (%s)\n", metrics.PhaseTestCount, metrics.PhaseDiffCount,
+		percent(metrics.PhaseDiffCount, metrics.PhaseTestCount))
+}
+
+//-------------------------------------------------------------
+// Run the merge to the writer, which is closed whether or not the run
+// succeeds, the error is the run's or else the close's
+//-------------------------------------------------------------
+func merge(ctx context.Context, sources []merger.Source, opts merger.Options,
+	w vcfio.Writer) (*merger.Metrics, error) {
+	m, err := merger.New(sources, opts, w)
+	if err != nil {
+		w.Close()
+		return nil, err
+	}
+	metrics, err := m.Run(ctx)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return metrics, nil
+}
+
+//-------------------------------------------------------------
+// Remove the output of a failed or interrupted run, and its index,
+// rather than leave them truncated
+//-------------------------------------------------------------
+func remove_output(path string) {
+	if path == "" || path == "-" {
+		return
+	}
+	for _, p := range []string{path, path + ".tbi", path + ".csi"} {
+		if err := os.Remove(p); err == nil {
+			log.Printf("Removed %s\n", p)
 		}
 	}
-	runParams := genometrics.GetRunParams(testnum, mafdelta, callrate, infoscore)
-	log.Printf("Params: %v\n", runParams)
+}
 
-	for key, value := range assaytype_filename {
-		fh, err := os.Open(value)
+//-------------------------------------------------------------
+// Read the --rename, --keep and --remove files
+//-------------------------------------------------------------
+func load_sample_selection() (map[string]map[string]string, map[string]bool, map[string]bool) {
+	sampleRename := make(map[string]map[string]string)
+	for _, spec := range renameSpecs {
+		fields := strings.SplitN(spec, "=", 2)
+		if len(fields) != 2 {
+			log.Fatalf("--rename %s: expected assay=file\n", spec)
+		}
+		if _, ok := sampleRename[fields[0]]; ok {
+			log.Fatalf("--rename %s: assay %s renamed twice\n", spec, fields[0])
+		}
+		rename, err := sample.ReadRenameFile(fields[1])
+		check(err)
+		sampleRename[fields[0]] = rename
+	}
+	var sampleKeep, sampleRemove map[string]bool
+	var err error
+	if keepFilePath != "" {
+		sampleKeep, err = sample.ReadListFile(keepFilePath)
 		check(err)
-		defer fh.Close()
-		gr, err := gzip.NewReader(fh)
+	}
+	if removeFilePath != "" {
+		sampleRemove, err = sample.ReadListFile(removeFilePath)
 		check(err)
-		defer gr.Close()
-		scanner := bufio.NewScanner(gr)
-		reader := bufio.NewReader(gr)
-		fscanners[key] = scanner
-		freaders[key] = reader
-	}
-	// handle file headers
-	headers := make(map[string][]string)
-	for assaytype, rdr := range freaders {
-		headers[assaytype], _ = get_sample_headers(rdr)
-		//fmt.Printf("%s hdr len = %d\n", assaytype, len(headers[assaytype]))
-	}
-	// Headers and combined header map
-	sample_name_map, sample_posn_map := sample.MakeSamplesByAssaytype(headers)
-	combocols := sample.GetCombinedSampleMap(sample_name_map)
-	// combocols := sample.GetCombinedSampleMapByAssaytypes(sample_name_map, assaytype_list)
-	colhdr_str, combo_names := vcfmerge.GetCombinedColumnHeaders(combocols)
-	//fmt.Printf("%s\n", "combined"+"\t"+colhdr_str)
-	print_headers()
-	fmt.Printf("%s\n", colhdr_str)
+	}
+	return sampleRename, sampleKeep, sampleRemove
+}
 
-	// read first records and capture keys (genomic positions)
-	records := make(map[string][]string)
-	keys := make(map[string]int64)
-	varids := make(map[string]string)
-	for assaytype, rdr := range freaders {
-		records[assaytype], keys[assaytype], varids[assaytype] = get_next_record_slice(rdr)
-	}
-	var genomet genometrics.AllMetrics
-	outctr := 0
-	// process until all files exhausted
-	for records_remain(keys) {
-		records, keys, varids = check_low_key_records(records, keys, varids)
-		output_from_low_key_records(records, keys, sample_posn_map, combocols, combo_names, threshold, &genomet)
-		outctr += 1
-		records, keys, varids = read_from_low_key_records(records, keys, freaders, varids)
-	}
-	log.Printf("EXIT,wrt=%d,allgeno=%d,2ol=%d,gt2ol=%d,mmc=%d,misstested=%d,missing=%d\n", outctr, genomet.AllGenoCount, genomet.TwoOverlapCount, genomet.GtTwoOverlapCount, genomet.MismatchCount, genomet.MissTestCount, genomet.MissingCount)
+func percent(n int, total int) string {
+	if total == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.2f%%", 100.0*float64(n)/float64(total))
 }
 
 //-------------------------------------------------------------
-// Get headers with column(sample) names
+// Load --config (nil if not given) and set the flags it gives a value
+// for, other than those given on the command line
 //-------------------------------------------------------------
-func get_sample_headers(rdr *bufio.Reader) ([]string, error) {
-	var sfx []string
+func load_config() *config.Config {
+	if configPath == "" {
+		return nil
+	}
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	values := [][2]string{
+		{"vcfprfx", cfg.VcfPrefix}, {"chr", cfg.Chrom}, {"contigs", cfg.Contigs}, {"bed", cfg.Bed},
+		{"resolver", cfg.Resolver}, {"palindrome", cfg.Palindrome}, {"out", cfg.Output.Path}, {"logfile", cfg.Output.Log},
+		{"rejfile", cfg.Output.Rejections}, {"concfile", cfg.Output.Concordance}, {"ibsfile", cfg.Output.Identity},
+		{"keep", cfg.Samples.Keep}, {"remove", cfg.Samples.Remove}, {"sample-order", cfg.Samples.Order},
+		{"orderfile", cfg.Samples.OrderFile}, {"unlisted", cfg.Samples.Unlisted}, {"collide", cfg.Samples.Collide},
+		{"sexfile", cfg.Samples.SexFile}, {"par", cfg.Samples.Par},
+	}
+	if cfg.Threshold != nil {
+		values = append(values, [2]string{"threshold", strconv.FormatFloat(*cfg.Threshold, 'g', -1, 64)})
+	}
+	if cfg.Output.IbsMin != nil {
+		values = append(values, [2]string{"ibsmin", strconv.FormatFloat(*cfg.Output.IbsMin, 'g', -1, 64)})
+	}
+	for _, reg := range cfg.Regions {
+		values = append(values, [2]string{"region", reg})
+	}
+	renamed := make([]string, 0, len(cfg.Samples.Rename))
+	for at := range cfg.Samples.Rename {
+		renamed = append(renamed, at)
+	}
+	sort.Strings(renamed)
+	for _, at := range renamed {
+		values = append(values, [2]string{"rename", at + "=" + cfg.Samples.Rename[at]})
+	}
+	given := flags_given()
+	for _, value := range values {
+		if value[1] != "" && !given[value[0]] {
+			if err := flag.Set(value[0], value[1]); err != nil {
+				log.Fatalf("%s: %s: %v\n", configPath, value[0], err)
+			}
+		}
+	}
+	return cfg
+}
 
-	eof := false
-	for !eof {
-		text, err := rdr.ReadString('\n')
-		if err == io.EOF {
-			return empty_record, err
+// Flags given on the command line, by long name
+func flags_given() map[string]bool {
+	long := map[string]string{"t": "tpltfile", "p": "paramfile", "l": "logfile", "o": "out", "v": "vcfprfx",
+		"h": "threshold", "c": "chr"}
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+		if name, ok := long[f.Name]; ok {
+			given[name] = true
 		}
-		text = strings.TrimRight(text, "\n")
-		if strings.HasPrefix(text, "##") {
-			//fmt.Printf("%s\n", text)
-		} else {
-			if strings.HasPrefix(text, "#") {
-				_, sfx = variant.GetVCFPrfx_Sfx(strings.Split(text, "\t"))
-				break
-			}
+	})
+	return given
+}
+
+//-------------------------------------------------------------
+// The assays and their (expanded) file paths: from --tpltfile, or the
+// config inputs when the config has them and --tpltfile is not given
+//-------------------------------------------------------------
+func get_inputs(cfg *config.Config) ([]string, map[string]string) {
+	var assaytype_list []string
+	var templates map[string]string
+	if cfg != nil && len(cfg.Inputs) > 0 && !flags_given()["tpltfile"] {
+		templates = make(map[string]string)
+		for _, in := range cfg.Inputs {
+			assaytype_list = append(assaytype_list, in.Assay)
+			templates[in.Assay] = in.Path
 		}
+		log.Printf("Inputs: %s\n", configPath)
+	} else {
+		var err error
+		assaytype_list, templates, err = config.ReadTemplate(tpltFilePath)
+		check(err)
+	}
+	if len(assaytype_list) == 0 {
+		log.Fatalf("no inputs in %s\n", tpltFilePath)
+	}
+	assaytype_filename := make(map[string]string)
+	for _, at := range assaytype_list {
+		assaytype_filename[at] = expand_template(templates[at], vcfPathPref, chr)
 	}
-	return sfx, nil
+	return assaytype_list, assaytype_filename
 }
 
 //-------------------------------------------------------------
-// Read a record from a single reader and split it to format a string slice
+// The assay manifest: the config inputs unless --manifest is given, or
+// else the manifest file
 //-------------------------------------------------------------
-func get_next_record_slice(rdr *bufio.Reader) ([]string, int64, string) {
-	posn := max_posn
-	data := empty_record
-	varid := ""
-	text, err := rdr.ReadString('\n')
-	if err == nil {
-		text = strings.TrimRight(text, "\n")
-		data = strings.Split(text, "\t")
-		posn = variant.GetPosn(data)
-		varid = variant.GetVarid(data)
-	}
-	return data, posn, varid
+func get_manifest(cfg *config.Config) assay.Manifest {
+	if cfg != nil && len(cfg.Inputs) > 0 && !flags_given()["manifest"] {
+		return config_manifest(cfg)
+	}
+	manifest, err := assay.ReadManifest(manifestPath)
+	check(err)
+	return manifest
 }
 
 //-------------------------------------------------------------
-// If all keys are high there are no records to be read
+// A manifest from the config inputs and their assay metadata
 //-------------------------------------------------------------
-func records_remain(keys map[string]int64) bool {
-	for _, pos := range keys {
-		if pos < max_posn {
-			return true
-		}
+func config_manifest(cfg *config.Config) assay.Manifest {
+	manifest := make(assay.Manifest)
+	for _, in := range cfg.Inputs {
+		manifest[in.Assay] = &assay.Assay{Name: in.Assay, Abbrev: in.Abbrev, Priority: in.Priority, Build: in.Build,
+			Panel: in.Panel, QC: in.QC.Values()}
 	}
-	return false
+	return manifest
 }
 
 //-------------------------------------------------------------
-// Cross check low key records to match alleles (when there
-// are more than one)
+// QC parameters by parameter file key: the parameter file (a missing
+// default file is taken as no parameters), with the config qc values
+// in place unless --paramfile is given
 //-------------------------------------------------------------
-func check_low_key_records(records map[string][]string, keys map[string]int64,
-	varids map[string]string) (map[string][]string, map[string]int64, map[string]string) {
-	low_keys := get_low_keys(keys)
-	key_count := 0
-	if len(low_keys) > 1 {
-		if key_count == 0 {
+func get_params(cfg *config.Config) map[string]string {
+	given := flags_given()
+	params, err := config.ReadParams(paramFilePath)
+	if os.IsNotExist(err) && !given["paramfile"] {
+		log.Printf("No parameter file %s\n", paramFilePath)
+		params, err = make(map[string]string), nil
+	}
+	check(err)
+	if cfg != nil && !given["paramfile"] {
+		for key, value := range cfg.QC.Values() {
+			params[key] = value
 		}
 	}
-	return records, keys, varids
+	return params
 }
-func output_from_low_key_records(records map[string][]string, keys map[string]int64,
-	sample_posn_map map[string]map[int]string,
-	combocols map[string]int, combo_names []string, threshold float64, genomet *genometrics.AllMetrics) {
-	//
-	low_keys := get_low_keys(keys)
-	low_key_at := make([]string, 0)
 
-	for at, _ := range low_keys {
-		low_key_at = append(low_key_at, at)
+//-------------------------------------------------------------
+// Fill a file template, the template may take the path prefix and
+// the chromosome, only the prefix, or neither (whole-genome files)
+//-------------------------------------------------------------
+func expand_template(tplt string, prefix string, chrom string) string {
+	switch strings.Count(tplt, "%s") {
+	case 0:
+		return tplt
+	case 1:
+		return fmt.Sprintf(tplt, prefix)
 	}
-	sort.Strings(low_key_at)
-
-	vcfrecords := make([][]string, 0, len(records))
-	rsid := ""
-	for _, at := range low_key_at {
-		rsid = variant.GetVarid(records[at])
-		rec := make([]string, 1, len(records[at])+1)
-		rec[0] = at
-		rec = append(rec, records[at]...)
-		vcfrecords = append(vcfrecords, rec)
-		//fmt.Printf("LOWKEY OUTPUT %s, %d\n", at, key)
-	}
-	var vcfd []vcfmerge.Vcfdata
-	comborec := vcfmerge.Mergeslices_full(vcfrecords, vcfd, rsid, sample_posn_map, combocols, combo_names, threshold, genomet)
-	rec_str := strings.Join(comborec, "\t")
-	fmt.Printf("%s\n", rec_str)
+	if chrom == merger.WholeGenome {
+		log.Fatalf("template %s is per-chromosome, cannot merge --chr %s\n", tplt, chrom)
+	}
+	return fmt.Sprintf(tplt, prefix, chrom)
 }
 
-func read_from_low_key_records(records map[string][]string, keys map[string]int64, rdrs map[string]*bufio.Reader, varids map[string]string) (map[string][]string, map[string]int64, map[string]string) {
-	low_keys := get_low_keys(keys)
-	for assaytype, _ := range low_keys {
-		records[assaytype], keys[assaytype], varids[assaytype] = get_next_record_slice(rdrs[assaytype])
+//-------------------------------------------------------------
+// Regions from --region and --bed, the merger sorts and merges them
+//-------------------------------------------------------------
+func get_regions(specs []string, bed_path string) []region.Region {
+	regions := make([]region.Region, 0)
+	for _, spec := range specs {
+		reg, err := region.Parse(spec)
+		check(err)
+		regions = append(regions, reg)
+	}
+	if bed_path != "" {
+		bed_regions, err := region.ReadBed(bed_path)
+		check(err)
+		regions = append(regions, bed_regions...)
 	}
-	return records, keys, varids
+	return regions
 }
 
-func get_low_keys(keys map[string]int64) map[string]int64 {
-	low_keys := make(map[string]int64)
-	low_key := max_posn
-	for _, pos := range keys {
-		if pos < low_key {
-			low_key = pos
-		}
+//-------------------------------------------------------------
+// Contig order from a user-supplied list (a file of names, one per line,
+// or a comma separated list), none to take it from the input headers
+//-------------------------------------------------------------
+func get_contigs(contig_list string) []string {
+	names := make([]string, 0)
+	if contig_list == "" {
+		return names
 	}
-	if low_key < max_posn {
-		for at, pos := range keys {
-			if pos == low_key {
-				low_keys[at] = pos
+	if data, err := os.ReadFile(contig_list); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if fields := strings.Fields(line); len(fields) > 0 && !strings.HasPrefix(fields[0], "#") {
+				names = append(names, fields[0])
 			}
 		}
+		return names
 	}
-	return low_keys
-}
-
-func print_headers() {
-	fmt.Printf("%s\n", "##fileformat=VCFv4.2")
-	fmt.Printf("%s\n", "##INFO=<ID=AC,Number=A,Type=Integer,Description=\"Allele count in genotypes\">")
-	fmt.Printf("%s\n", "##INFO=<ID=AN,Number=1,Type=Integer,Description=\"Total number of alleles in called genotypes\">")
-	fmt.Printf("%s\n", "##FORMAT=<ID=GT,Number=1,Type=String,Description=\"Genotype\">")
-	fmt.Printf("%s\n", "##INFO=<ID=RefPanelAF,Number=A,Type=Float,Description=\"Allele frequency in imputation reference panel\">")
-	fmt.Printf("%s\n", "##FORMAT=<ID=DS,Number=1,Type=Float,Description=\"Genotype dosage\">")
-	fmt.Printf("%s\n", "##FORMAT=<ID=GP,Number=G,Type=Float,Description=\"Genotype posterior probabilities\">")
-	fmt.Printf("%s\n", "##FORMAT=<ID=AT,Number=1,Type=String,Description=\"Assay Type\">")
-	fmt.Printf("%s\n", "##INFO=<ID=TYPED,Number=0,Type=Flag,Description=\"Typed in input data\">")
+	return strings.Split(contig_list, ",")
 }
@@ -2,6 +2,7 @@ package genometrics
 
 import (
 	//	"fmt"
+	"math"
 	"strconv"
 	"strings"
 	"variant"
@@ -16,6 +17,57 @@ type AllMetrics struct {
 	MismatchCount     int
 	MissTestCount     int
 	MissingCount      int
+	PhaseTestCount    int
+	PhaseDiffCount    int
+	Concordance       map[ConcordanceKey]*ConcordanceCounts
+}
+
+//-----------------------------------------------
+// Concordance: the calls of a sample compared between two assays, over
+// the variants both assays typed (Missing1, Missing2: the call of Assay1,
+// Assay2 alone is missing)
+//-----------------------------------------------
+type ConcordanceKey struct {
+	Sample string
+	Assay1 string
+	Assay2 string
+}
+
+type ConcordanceCounts struct {
+	Compared   int
+	Concordant int
+	Discordant int
+	Missing1   int
+	Missing2   int
+}
+
+// Count one comparison of the GTs of a sample in two assays, phase ignored
+func (am *AllMetrics) AddConcordance(sample string, at1 string, gt1 string, at2 string, gt2 string) {
+	if at2 < at1 {
+		at1, gt1, at2, gt2 = at2, gt2, at1, gt1
+	}
+	if am.Concordance == nil {
+		am.Concordance = make(map[ConcordanceKey]*ConcordanceCounts)
+	}
+	key := ConcordanceKey{Sample: sample, Assay1: at1, Assay2: at2}
+	cc, ok := am.Concordance[key]
+	if !ok {
+		cc = &ConcordanceCounts{}
+		am.Concordance[key] = cc
+	}
+	cc.Compared += 1
+	miss1, miss2 := genotype_class(gt1) == "miss", genotype_class(gt2) == "miss"
+	switch {
+	case miss1 && miss2:
+	case miss1:
+		cc.Missing1 += 1
+	case miss2:
+		cc.Missing2 += 1
+	case variant.SameGenotype(gt1, gt2):
+		cc.Concordant += 1
+	default:
+		cc.Discordant += 1
+	}
 }
 
 type RunParameters struct {
@@ -25,21 +77,44 @@ type RunParameters struct {
 	InfoScore float64
 }
 
+// FILTER ids for assay records failing QC
+const (
+	FilterCallRate  = "LowCallRate"
+	FilterInfoScore = "LowInfo"
+	FilterMafDelta  = "MafDelta"
+)
+
 // caller passes a string array representing a whole VCF
 // record, including prefix
 func Hwe_exact_for_record(rec []string, threshold float64) float64 {
-	homref, homalt, het, _, _, _, _ := get_genotype_counts(rec, threshold)
-	return SNPHWE(het, homref, homalt)
+	gc := get_genotype_counts(rec, threshold, nil)
+	return SNPHWE(gc.het, gc.homr, gc.homa)
 }
 
 // return all SNP metrics
 // CR, RAF, AAF, MAF, HWE_P
 func Metrics_for_record(rec []string, threshold float64) (float64, float64,
 	float64, float64, float64, int, int, int, int, int, int, float64) {
-	homref, homalt, het, n, miss, dot, refPAF := get_genotype_counts(rec, threshold)
-	cr := float64(homref+het+homalt) / float64(n)
-	raf := float64(2*homref+het) / float64(2*n)
-	aaf := float64(2*homalt+het) / float64(2*n)
+	return Metrics_for_record_ploidy(rec, threshold, nil)
+}
+
+// Metrics_for_record with the ploidy of each sample (nil for all diploid):
+// haploid calls count one allele, samples of ploidy 0 are left out, and HWE
+// is tested on the diploid calls alone (females on X)
+func Metrics_for_record_ploidy(rec []string, threshold float64, ploidies []int) (float64, float64,
+	float64, float64, float64, int, int, int, int, int, int, float64) {
+	gc := get_genotype_counts(rec, threshold, ploidies)
+	homref, homalt, het, n := gc.homr, gc.homa, gc.het, gc.n
+	alleles := float64(2*gc.ndip + gc.nhap)
+	// no samples or no called alleles leave the rates at 0, not NaN
+	cr, raf, aaf := 0.0, 0.0, 0.0
+	if n > 0 {
+		cr = float64(homref+het+homalt+gc.hapr+gc.hapa) / float64(n)
+	}
+	if alleles > 0 {
+		raf = float64(2*homref+het+gc.hapr) / alleles
+		aaf = float64(2*homalt+het+gc.hapa) / alleles
+	}
 	maf := aaf
 	if raf < aaf {
 		maf = raf
@@ -50,7 +125,40 @@ func Metrics_for_record(rec []string, threshold float64) (float64, float64,
 		obs_homc = homalt
 		obs_homr = homref
 	}
-	return cr, raf, aaf, maf, SNPHWE(het, homref, homalt), het, obs_homc, obs_homr, n, miss, dot, refPAF
+	return cr, raf, aaf, maf, SNPHWE(het, homref, homalt), het, obs_homc, obs_homr, n, gc.miss, gc.dot, gc.refPAF
+}
+
+// QC an assay record against the run parameters, returns the call rate,
+// the info score and the FILTER ids of failed tests (empty for a pass).
+// A zero parameter disables its test, the call rate and MAF delta tests
+// need at least TestNum genotyped samples, ploidies as Metrics_for_record_ploidy
+func QCRecord(rec []string, threshold float64, params RunParameters, ploidies []int) (float64, float64, []string) {
+	filters := make([]string, 0)
+	infoscore, hasinfo := variant.GetInfoScore(rec)
+	if hasinfo && params.InfoScore > 0.0 && infoscore < params.InfoScore {
+		filters = append(filters, FilterInfoScore)
+	}
+	_, sfx := variant.GetVCFPrfx_Sfx(rec)
+	if len(sfx) == 0 {
+		return 0.0, infoscore, filters
+	}
+	cr, _, _, maf, _, _, _, _, n, _, _, _ := Metrics_for_record_ploidy(rec, threshold, ploidies)
+	if n == 0 || n < params.TestNum {
+		return cr, infoscore, filters
+	}
+	if params.CallRate > 0.0 && cr < params.CallRate {
+		filters = append(filters, FilterCallRate)
+	}
+	// an unparsable RefPanelAF skips the test rather than comparing with 0
+	if refmaf, ok := variant.GetRefPanelAf(rec); ok && params.MafDelta > 0.0 {
+		if refmaf > 0.5 {
+			refmaf = 1.0 - refmaf
+		}
+		if math.Abs(maf-refmaf) > params.MafDelta {
+			filters = append(filters, FilterMafDelta)
+		}
+	}
+	return cr, infoscore, filters
 }
 
 func GetRunParams(testnum string, mafdelta string, callrate string, infoscore string) RunParameters {
@@ -97,6 +205,9 @@ func SNPHWE(obs_hets int, obs_hom1 int, obs_hom2 int) float64 {
 
 	rare_copies := 2*obs_homr + obs_hets
 	genotypes := obs_hets + obs_homc + obs_homr
+	if genotypes == 0 {
+		return 1.0
+	}
 
 	het_probs := make([]float64, rare_copies+1, rare_copies+1)
 
@@ -152,42 +263,93 @@ func SNPHWE(obs_hets int, obs_hom1 int, obs_hom2 int) float64 {
 
 }
 
-func get_genotype_counts(rec []string, threshold float64) (int, int, int, int, int, int, float64) {
-	homr := 0
-	homa := 0
-	het := 0
-	n := 0
-	miss := 0
-	dot := 0
+// Class of a GT of any ploidy and allele count: hom ref, het (any two
+// alleles differ), hom alt, or missing (any allele missing)
+func genotype_class(gt string) string {
+	alleles := strings.FieldsFunc(gt, func(r rune) bool { return r == '/' || r == '|' })
+	if len(alleles) == 0 {
+		return "miss"
+	}
+	for _, allele := range alleles {
+		if allele == "." {
+			return "miss"
+		}
+	}
+	for _, allele := range alleles[1:] {
+		if allele != alleles[0] {
+			return "het"
+		}
+	}
+	if alleles[0] == "0" {
+		return "homr"
+	}
+	return "homa"
+}
+
+//-----------------------------------------------
+// genotypeCounts: calls in a record, diploid by class, haploid by allele,
+// n samples with a value (ndip, nhap of them diploid, haploid)
+//-----------------------------------------------
+type genotypeCounts struct {
+	homr   int
+	homa   int
+	het    int
+	hapr   int
+	hapa   int
+	n      int
+	ndip   int
+	nhap   int
+	miss   int
+	dot    int
+	refPAF float64
+}
+
+func get_genotype_counts(rec []string, threshold float64, ploidies []int) genotypeCounts {
+	var gc genotypeCounts
 
 	prfx, sfx := variant.GetVCFPrfx_Sfx(rec)
 	probidx := variant.GetProbidx(prfx)
-	refPAF := variant.GetRefPanelAf(prfx)
+	gc.refPAF, _ = variant.GetRefPanelAf(prfx)
+	_, alt := variant.GetAlleles(prfx)
+	nalleles := len(strings.Split(alt, ",")) + 1
 
-	for _, geno := range sfx {
+	for j, geno := range sfx {
+		ploidy := 2
+		if j < len(ploidies) {
+			ploidy = ploidies[j]
+		}
+		if ploidy == 0 {
+			continue
+		}
 		if geno != "." {
-			n += 1
-			geno = variant.Get_geno(geno, threshold, probidx)
-			geno_a := strings.Split(geno, ":")
-			if geno_a[0] == "0/0" {
-				homr += 1
-			}
-			if geno_a[0] == "0/1" {
-				het += 1
+			gc.n += 1
+			if ploidy == 1 {
+				gc.nhap += 1
+			} else {
+				gc.ndip += 1
 			}
-			if geno_a[0] == "1/0" {
-				het += 1
+			if probidx >= 0 || ploidy == 1 {
+				geno = variant.Get_geno_ploidy(geno, threshold, probidx, ploidy, nalleles)
 			}
-			if geno_a[0] == "1/1" {
-				homa += 1
-			}
-			if geno_a[0] == "./." {
-				miss += 1
+			geno_a := strings.Split(geno, ":")
+			switch class := genotype_class(geno_a[0]); {
+			case class == "miss":
+				gc.miss += 1
+			case ploidy == 1 && class == "homr":
+				gc.hapr += 1
+			case ploidy == 1:
+				gc.hapa += 1
+			case class == "homr":
+				gc.homr += 1
+			case class == "het":
+				gc.het += 1
+			case class == "homa":
+				gc.homa += 1
 			}
 		} else {
-			dot += 1
+			gc.dot += 1
 		}
 	}
 
-	return homr, homa, het, n, miss, dot, refPAF
+	return gc
 }
@@ -0,0 +1,326 @@
+// Identity-by-state between the samples of the input assays, to find sample
+// swaps (same name, unrelated genotypes) and duplicates (different names,
+// identical genotypes) before the merge
+package ibs
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"region"
+	"sort"
+	"strconv"
+	"strings"
+	"variant"
+)
+
+// Sites used: biallelic, common and well called in the first assay, at most
+// MaxSites shared out evenly between the windows sampled
+const (
+	MinMaf      = 0.05
+	MinCallRate = 0.95
+	MaxSites    = 10000
+	MinSites    = 100
+	Windows     = 500
+	WindowSize  = 200000
+)
+
+// Pair flags
+const (
+	FlagMatch     = "match"
+	FlagSwap      = "swap"
+	FlagDuplicate = "duplicate"
+)
+
+//-----------------------------------------------
+// Pair: two samples compared, IBS over Sites typed in both
+//-----------------------------------------------
+type Pair struct {
+	Assay1  string
+	Sample1 string
+	Assay2  string
+	Sample2 string
+	Sites   int
+	IBS     float64
+	Flag    string
+}
+
+//-----------------------------------------------
+// Collector: ALT allele counts (-1 missing) per assay, indexed by site then
+// sample (nil for a site the assay did not type), sites are chosen from the
+// first assay added, up to quota in each window, later assays only fill them
+//-----------------------------------------------
+type Collector struct {
+	threshold float64
+	sites     map[variant.VarKey]int
+	genos     map[string][][]int8
+	filled    map[string]int
+	first     string
+	windows   []region.Region
+	byChrom   map[string][]int
+	chosen    []int
+	quota     int
+}
+
+//------------------------------------------------------------------------------
+// A collector sampling sites from the windows given (sorted, not overlapping),
+// or from the first MaxSites of the first assay when there are none
+//------------------------------------------------------------------------------
+func NewCollector(threshold float64, windows []region.Region) *Collector {
+	c := &Collector{threshold: threshold, sites: make(map[variant.VarKey]int),
+		genos: make(map[string][][]int8), filled: make(map[string]int),
+		windows: windows, byChrom: make(map[string][]int), quota: MaxSites}
+	if len(windows) > 0 {
+		c.quota = (MaxSites + len(windows) - 1) / len(windows)
+	}
+	for i, win := range windows {
+		chrom := variant.KeyChrom(win.Chrom)
+		c.byChrom[chrom] = append(c.byChrom[chrom], i)
+	}
+	c.chosen = make([]int, len(windows))
+	return c
+}
+
+// Index of the window holding a record, -1 if none, 0 when there are no
+// windows
+func (c *Collector) window(rec []string) int {
+	if len(c.windows) == 0 {
+		return 0
+	}
+	wins := c.byChrom[variant.KeyChrom(variant.GetChrom(rec))]
+	posn := variant.GetPosn(rec) - 1
+	i := sort.Search(len(wins), func(i int) bool { return c.windows[wins[i]].End > posn })
+	if i == len(wins) || c.windows[wins[i]].Beg > posn {
+		return -1
+	}
+	return wins[i]
+}
+
+//------------------------------------------------------------------------------
+// Has the collector all it needs from an assay: for the first assay every
+// window holds its quota of sites, for the others every site is filled
+//------------------------------------------------------------------------------
+func (c *Collector) Done(assay string) bool {
+	if c.first == "" || assay == c.first {
+		if len(c.windows) == 0 {
+			return len(c.sites) >= MaxSites
+		}
+		for _, n := range c.chosen {
+			if n < c.quota {
+				return false
+			}
+		}
+		return true
+	}
+	return c.filled[assay] == len(c.sites)
+}
+
+// Number of sites chosen
+func (c *Collector) Sites() int {
+	return len(c.sites)
+}
+
+//------------------------------------------------------------------------------
+// Add a record of an assay, a record with REF/ALT swapped against the site
+// chosen has its counts swapped
+//------------------------------------------------------------------------------
+func (c *Collector) Add(assay string, rec []string) {
+	if c.first == "" {
+		c.first = assay
+	}
+	ref, alt := variant.GetAlleles(rec)
+	if strings.Contains(alt, ",") || alt == "." {
+		return
+	}
+	key := variant.GetKey(rec)
+	swapped := false
+	site, ok := c.sites[key]
+	if !ok && assay != c.first {
+		key.Ref, key.Alt = alt, ref
+		site, ok = c.sites[key]
+		swapped = true
+	}
+	win := -1
+	if !ok {
+		if assay != c.first || len(c.sites) >= MaxSites {
+			return
+		}
+		if win = c.window(rec); win < 0 || (len(c.windows) > 0 && c.chosen[win] >= c.quota) {
+			return
+		}
+	}
+	if ok && site < len(c.genos[assay]) && c.genos[assay][site] != nil {
+		return
+	}
+	counts := c.alt_counts(rec)
+	if !ok {
+		if !common(counts) {
+			return
+		}
+		site = len(c.sites)
+		c.sites[key] = site
+		if len(c.windows) > 0 {
+			c.chosen[win]++
+		}
+	}
+	if swapped {
+		for i, count := range counts {
+			if count >= 0 {
+				counts[i] = 2 - count
+			}
+		}
+	}
+	for len(c.genos[assay]) <= site {
+		c.genos[assay] = append(c.genos[assay], nil)
+	}
+	c.genos[assay][site] = counts
+	c.filled[assay]++
+}
+
+// ALT allele count of each diploid call, -1 for a missing or non-diploid call
+func (c *Collector) alt_counts(rec []string) []int8 {
+	prfx, sfx := variant.GetVCFPrfx_Sfx(rec)
+	probidx := variant.GetProbidx(prfx)
+	counts := make([]int8, len(sfx))
+	for i, geno := range sfx {
+		if probidx >= 0 {
+			geno = variant.Get_geno(geno, c.threshold, probidx)
+		}
+		counts[i] = -1
+		alleles := strings.Split(variant.UnphasedGenotype(strings.SplitN(geno, ":", 2)[0]), "/")
+		if len(alleles) != 2 || alleles[0] == "." || alleles[1] == "." {
+			continue
+		}
+		counts[i] = 0
+		for _, allele := range alleles {
+			if allele != "0" {
+				counts[i]++
+			}
+		}
+	}
+	return counts
+}
+
+// Call rate and MAF of a site high enough for it to be used
+func common(counts []int8) bool {
+	called, alts := 0, 0
+	for _, count := range counts {
+		if count >= 0 {
+			called++
+			alts += int(count)
+		}
+	}
+	if called == 0 || float64(called) < MinCallRate*float64(len(counts)) {
+		return false
+	}
+	maf := float64(alts) / float64(2*called)
+	if maf > 0.5 {
+		maf = 1.0 - maf
+	}
+	return maf >= MinMaf
+}
+
+//------------------------------------------------------------------------------
+// Compare every pair of samples across the assays (samples by assay, assays
+// in order), pairs with fewer than MinSites sites typed in both are not
+// compared. Returned are the pairs with the same name, flagged as a match or
+// a swap (IBS below minibs), and the differently named pairs with IBS of at
+// least minibs, flagged as duplicates
+//------------------------------------------------------------------------------
+func (c *Collector) Compare(assays []string, samples map[string][]string, minibs float64) []Pair {
+	calls := make(map[string][][]int8, len(assays))
+	for _, at := range assays {
+		calls[at] = c.sample_calls(at, len(samples[at]))
+	}
+	pairs := make([]Pair, 0)
+	for a, at1 := range assays {
+		for _, at2 := range assays[a:] {
+			for i, s1 := range samples[at1] {
+				for j, s2 := range samples[at2] {
+					if at1 == at2 && j <= i {
+						continue
+					}
+					if at1 == at2 && s1 == s2 {
+						continue
+					}
+					ibs, sites := shared_ibs(calls[at1][i], calls[at2][j])
+					if sites < MinSites {
+						continue
+					}
+					pair := Pair{Assay1: at1, Sample1: s1, Assay2: at2, Sample2: s2, Sites: sites, IBS: ibs}
+					switch {
+					case s1 == s2 && ibs < minibs:
+						pair.Flag = FlagSwap
+					case s1 == s2:
+						pair.Flag = FlagMatch
+					case ibs >= minibs:
+						pair.Flag = FlagDuplicate
+					default:
+						continue
+					}
+					pairs = append(pairs, pair)
+				}
+			}
+		}
+	}
+	sort.SliceStable(pairs, func(i, j int) bool {
+		return pairs[i].Flag != FlagMatch && pairs[j].Flag == FlagMatch
+	})
+	return pairs
+}
+
+// Counts of an assay by sample then site, every site of the collector
+// present (-1 where the assay did not type it), for the pairwise scan
+func (c *Collector) sample_calls(assay string, nsamples int) [][]int8 {
+	calls := make([][]int8, nsamples)
+	for i := range calls {
+		calls[i] = make([]int8, len(c.sites))
+		for site := range calls[i] {
+			calls[i][site] = -1
+		}
+	}
+	for site, counts := range c.genos[assay] {
+		for i, count := range counts {
+			if i < nsamples {
+				calls[i][site] = count
+			}
+		}
+	}
+	return calls
+}
+
+// IBS of two samples (alleles shared / 2 per site) and the sites both called
+func shared_ibs(calls1 []int8, calls2 []int8) (float64, int) {
+	shared, sites := 0, 0
+	for site, count1 := range calls1 {
+		count2 := calls2[site]
+		if count1 < 0 || count2 < 0 {
+			continue
+		}
+		diff := int(count1) - int(count2)
+		if diff < 0 {
+			diff = -diff
+		}
+		shared += 2 - diff
+		sites++
+	}
+	if sites == 0 {
+		return 0.0, 0
+	}
+	return float64(shared) / float64(2*sites), sites
+}
+
+var pairColumns = []string{"ASSAY1", "SAMPLE1", "ASSAY2", "SAMPLE2", "SITES", "IBS", "FLAG"}
+
+//------------------------------------------------------------------------------
+// Pairs as a TSV report
+//------------------------------------------------------------------------------
+func WritePairs(w io.Writer, pairs []Pair) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("#" + strings.Join(pairColumns, "\t") + "\n")
+	for _, pair := range pairs {
+		bw.WriteString(strings.Join([]string{pair.Assay1, pair.Sample1, pair.Assay2, pair.Sample2,
+			strconv.Itoa(pair.Sites), fmt.Sprintf("%.4f", pair.IBS), pair.Flag}, "\t") + "\n")
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,186 @@
+package ibs
+
+import (
+	"bytes"
+	"fmt"
+	"region"
+	"strings"
+	"testing"
+)
+
+// A GT for the sample with seed at site i, from a small LCG so that
+// samples with different seeds are unrelated
+func test_gt(seed int, i int) string {
+	x := uint32(seed*7919 + i*104729)
+	for n := 0; n < 3; n++ {
+		x = x*1664525 + 1013904223
+	}
+	return []string{"0/0", "0/1", "0/1", "1/1"}[x>>30]
+}
+
+// A biallelic record at 1:posn of the samples given by seed, REF/ALT
+// swapped (and the calls recoded) when swap is set
+func test_record(posn int, seeds []int, swap bool) []string {
+	rec := []string{"1", fmt.Sprint(posn), fmt.Sprintf("rs%d", posn), "A", "G", ".", "PASS", ".", "GT"}
+	if swap {
+		rec[3], rec[4] = "G", "A"
+	}
+	for _, seed := range seeds {
+		gt := test_gt(seed, posn)
+		if swap {
+			gt = map[string]string{"0/0": "1/1", "0/1": "0/1", "1/1": "0/0"}[gt]
+		}
+		rec = append(rec, gt)
+	}
+	return rec
+}
+
+func TestCompare(t *testing.T) {
+	// b's S1 is a's S1, b's S2 is a's S3 (a swap) and b's S4 is a's S2 (a
+	// duplicate); c types too few sites to be compared
+	samples := map[string][]string{"a": {"S1", "S2", "S3"}, "b": {"S1", "S2", "S4"}, "c": {"S1"}}
+	c := NewCollector(0.9, nil)
+	for posn := 1; posn <= 200; posn++ {
+		c.Add("a", test_record(posn, []int{1, 2, 3}, false))
+	}
+	for posn := 1; posn <= 200; posn++ {
+		c.Add("b", test_record(posn, []int{1, 3, 2}, posn%3 == 0))
+		if posn <= MinSites/2 {
+			c.Add("c", test_record(posn, []int{1}, false))
+		}
+	}
+	pairs := c.Compare([]string{"a", "b", "c"}, samples, 0.9)
+	want := map[string]string{
+		"a:S2 b:S2": FlagSwap,
+		"a:S2 b:S4": FlagDuplicate,
+		"a:S3 b:S2": FlagDuplicate,
+		"a:S1 b:S1": FlagMatch,
+		"a:S3 b:S3": "",
+	}
+	got := make(map[string]Pair)
+	for _, pair := range pairs {
+		got[pair.Assay1+":"+pair.Sample1+" "+pair.Assay2+":"+pair.Sample2] = pair
+	}
+	for key, flag := range want {
+		pair, ok := got[key]
+		if flag == "" {
+			if ok {
+				t.Errorf("%s reported as %s", key, pair.Flag)
+			}
+			continue
+		}
+		if !ok || pair.Flag != flag {
+			t.Errorf("%s: %+v, want flagged %s", key, pair, flag)
+			continue
+		}
+		if identical := flag != FlagSwap; (pair.IBS == 1.0) != identical {
+			t.Errorf("%s: IBS %.4f", key, pair.IBS)
+		}
+	}
+	if len(pairs) != 4 {
+		t.Errorf("%d pairs reported, want 4: %v", len(pairs), pairs)
+	}
+	for _, pair := range pairs {
+		if pair.Assay1 == "c" || pair.Assay2 == "c" {
+			t.Errorf("c compared over %d sites: %+v", pair.Sites, pair)
+		}
+	}
+	// flagged pairs first, then matches
+	if pairs[len(pairs)-1].Flag != FlagMatch {
+		t.Errorf("last pair %+v, want the match", pairs[len(pairs)-1])
+	}
+}
+
+func TestSharedIbs(t *testing.T) {
+	tests := []struct {
+		calls1, calls2 []int8
+		ibs            float64
+		sites          int
+	}{
+		{[]int8{0, 1, 2}, []int8{0, 1, 2}, 1.0, 3},
+		{[]int8{0, 0, 2}, []int8{2, 1, 2}, 0.5, 3},
+		{[]int8{0, -1, 2, 1}, []int8{-1, 1, 2, 2}, 0.75, 2},
+		{[]int8{-1, -1}, []int8{0, 0}, 0.0, 0},
+	}
+	for _, tt := range tests {
+		if ibs, sites := shared_ibs(tt.calls1, tt.calls2); ibs != tt.ibs || sites != tt.sites {
+			t.Errorf("shared_ibs(%v, %v) = %.3f, %d, want %.3f, %d", tt.calls1, tt.calls2, ibs, sites, tt.ibs, tt.sites)
+		}
+	}
+}
+
+func TestAddSites(t *testing.T) {
+	c := NewCollector(0.9, nil)
+	c.Add("a", []string{"1", "10", "rs10", "A", "G,T", ".", "PASS", ".", "GT", "0/1", "1/2"})
+	c.Add("a", []string{"1", "20", "rs20", "A", "G", ".", "PASS", ".", "GT", "0/0", "0/0"})
+	c.Add("a", []string{"1", "30", "rs30", "A", "G", ".", "PASS", ".", "GT", "./.", "0/1"})
+	c.Add("a", []string{"1", "40", "rs40", "A", "G", ".", "PASS", ".", "GT:GP", "0/0:0,1,0", "1/1:0,0.95,0.05"})
+	// multi-allelic, monomorphic and poorly called sites are not used
+	if len(c.sites) != 1 {
+		t.Fatalf("%d sites chosen, want 1", len(c.sites))
+	}
+	// GT called from GP at the threshold
+	if got := fmt.Sprint(c.genos["a"][0]); got != "[1 1]" {
+		t.Errorf("counts %s, want [1 1]", got)
+	}
+}
+
+func TestWritePairs(t *testing.T) {
+	var buf bytes.Buffer
+	pairs := []Pair{{Assay1: "a", Sample1: "S1", Assay2: "b", Sample2: "S1", Sites: 150, IBS: 0.61234, Flag: FlagSwap}}
+	if err := WritePairs(&buf, pairs); err != nil {
+		t.Fatalf("WritePairs: %v", err)
+	}
+	want := "#" + strings.Join(pairColumns, "\t") + "\na\tS1\tb\tS1\t150\t0.6123\tswap\n"
+	if buf.String() != want {
+		t.Errorf("report %q, want %q", buf.String(), want)
+	}
+}
+
+func TestWindowQuota(t *testing.T) {
+	// each of the two windows holds half of MaxSites, 20:1-100 is outside
+	windows := []region.Region{{Chrom: "1", Beg: 0, End: 2 * MaxSites}, {Chrom: "2", Beg: 1000, End: 2000}}
+	c := NewCollector(0.9, windows)
+	het := func(chrom string, posn int) []string {
+		return []string{chrom, fmt.Sprint(posn), ".", "A", "G", ".", "PASS", ".", "GT", "0/1", "0/1"}
+	}
+	for posn := 1; posn <= 2*MaxSites; posn++ {
+		c.Add("a", het("1", posn))
+	}
+	if c.Done("a") {
+		t.Errorf("a done with window 2 empty")
+	}
+	for _, chrom := range []string{"2", "chr20"} {
+		for posn := 1001; posn <= 1100; posn++ {
+			c.Add("a", het(chrom, posn))
+		}
+	}
+	tests := []struct {
+		window int
+		want   int
+	}{
+		{0, MaxSites / 2},
+		{1, 100},
+	}
+	for _, tt := range tests {
+		if c.chosen[tt.window] != tt.want {
+			t.Errorf("window %v: %d sites, want %d", windows[tt.window], c.chosen[tt.window], tt.want)
+		}
+	}
+	if c.Sites() != MaxSites/2+100 {
+		t.Errorf("%d sites, want %d", c.Sites(), MaxSites/2+100)
+	}
+	// a later assay is done once every site is filled
+	for posn := 1; posn <= MaxSites/2; posn++ {
+		if c.Done("b") {
+			t.Fatalf("b done at %d", posn)
+		}
+		c.Add("b", het("1", posn))
+	}
+	for posn := 1001; posn <= 1100; posn++ {
+		c.Add("b", het("2", posn))
+	}
+	if !c.Done("b") {
+		t.Errorf("b not done with every site filled")
+	}
+}
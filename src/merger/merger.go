@@ -0,0 +1,1330 @@
+// Merge 2 to n VCF inputs covering the same genomic range, as a library: a
+// Merger takes the input sources, the options and an output writer, Run
+// returns the merge metrics, or an error (HeaderError, UnsortedError,
+// *vcfio.ParseError for a malformed record, the context's error when it is
+// cancelled) in place of exiting
+package merger
+
+import (
+	"assay"
+	"context"
+	"errors"
+	"fmt"
+	"genometrics"
+	"ibs"
+	"io"
+	"log"
+	"math"
+	"ploidy"
+	"region"
+	"report"
+	"sample"
+	"sort"
+	"strings"
+	"variant"
+	"vcfheader"
+	"vcfio"
+	"vcfmerge"
+)
+
+// WholeGenome as Options.Chrom merges every chromosome, as "" does
+const WholeGenome = "all"
+
+// Options.Collide modes
+const (
+	CollideMerge  = "merge"
+	CollideSuffix = "suffix"
+)
+
+// Options.Palindrome modes: palindromic alleles against their reverse are
+// dropped, or resolved by ALT allele frequency where the MAF is at most
+// palindromeMaxMaf
+const (
+	PalindromeDrop = "drop"
+	PalindromeAF   = "af"
+)
+
+const palindromeMaxMaf = 0.4
+
+// Options.SampleOrder and Options.Unlisted choices
+const (
+	OrderAlpha     = "alpha"
+	OrderAssay     = "assay"
+	OrderFile      = "file"
+	UnlistedAppend = "append"
+	UnlistedDrop   = "drop"
+)
+
+var empty_record = []string{}
+
+//-----------------------------------------------
+// Source: an input assay, read from Reader, or opened from Path when Reader
+// is nil. Path is also used to find a .tbi or .csi index for Regions, and to
+// read the input again for the identity check ("" and "-" cannot be)
+//-----------------------------------------------
+type Source struct {
+	Assay  string
+	Path   string
+	Reader vcfio.Reader
+}
+
+//-----------------------------------------------
+// Options: the merge run, the zero value of a field is its default
+//-----------------------------------------------
+type Options struct {
+	Chrom        string                       // chromosome to merge, "" or WholeGenome for all
+	Contigs      []string                     // contig order, from the input ##contig lines if empty
+	Regions      []region.Region              // regions to merge, all of each input if empty
+	Threshold    float64                      // genotype probability threshold
+	Resolver     string                       // vcfmerge resolver name, posterior if ""
+	Params       map[string]string            // QC thresholds by parameter file key
+	Manifest     assay.Manifest               // assay metadata, nil for none, else listing every input
+	Rename       map[string]map[string]string // old to new sample IDs, by assay
+	Keep         map[string]bool              // sample IDs to keep (after renaming), nil keeps all
+	Remove       map[string]bool              // sample IDs to leave out (after renaming)
+	Collide      string                       // CollideMerge (the default) or CollideSuffix
+	Palindrome   string                       // PalindromeDrop (the default) or PalindromeAF
+	SampleOrder  string                       // OrderAlpha (the default), OrderAssay or OrderFile
+	Order        []string                     // sample IDs in output order, for OrderFile
+	Unlisted     string                       // UnlistedAppend (the default) or UnlistedDrop
+	Ploidy       *ploidy.Model                // sample sexes, nil for all diploid
+	StrictHeader bool                         // conflicting input header lines are an error, not logged and reported
+	HeaderLines  []string                     // meta lines added to the end of the output header
+	Rejections   io.Writer                    // allele rejection report, may be nil
+	Concordance  io.Writer                    // per-sample concordance report, may be nil
+	Identity     io.Writer                    // identity report, nil for no identity check
+	IbsMin       float64                      // IBS at which two samples are the same individual
+	Log          *log.Logger                  // progress log, nil discards it
+}
+
+//-----------------------------------------------
+// Metrics: the genotype metrics of a run, the merged records written,
+// rejection report rows by action, the identity check pairs reported and
+// the input header definitions that conflicted with those written
+//-----------------------------------------------
+type Metrics struct {
+	genometrics.AllMetrics
+	Records         int
+	Rejections      map[string]int
+	Identity        []ibs.Pair
+	HeaderConflicts []vcfheader.Conflict
+}
+
+//-----------------------------------------------
+// HeaderError: input headers that cannot be merged, the header of Assay
+// against that of Other
+//-----------------------------------------------
+type HeaderError struct {
+	Assay string
+	Other string
+	Msg   string
+}
+
+func (e *HeaderError) Error() string {
+	return fmt.Sprintf("%s: header does not match %s: %s", e.Assay, e.Other, e.Msg)
+}
+
+//-----------------------------------------------
+// UnsortedError: an input site out of contig order and position
+//-----------------------------------------------
+type UnsortedError struct {
+	Assay string
+	Chrom string
+	Posn  int64
+}
+
+func (e *UnsortedError) Error() string {
+	return fmt.Sprintf("%s: input not sorted by contig order and position at %s:%d", e.Assay, e.Chrom, e.Posn)
+}
+
+//-----------------------------------------------
+// Merger: a merge of the sources to a writer, run once
+//-----------------------------------------------
+type Merger struct {
+	sources       []Source
+	opts          Options
+	w             vcfio.Writer
+	log           *log.Logger
+	ran           bool
+	assaytypeList []string
+	readers       map[string]vcfio.Reader
+	resolver      vcfmerge.Resolver
+	contigOrder   variant.ContigOrder
+	contigs       []vcfheader.Contig
+	rejWriter     *report.RejectionWriter
+	checkedSite   variant.VarKey
+	assayParams   map[string]genometrics.RunParameters
+	headerNumbers map[string]string
+	sampleBase    map[string]string
+	assaySamples  map[string][]string
+	ploidyCache   map[string][]int
+}
+
+//------------------------------------------------------------------------------
+// A Merger of sources (in template order, the first is the reference assay)
+// to w, the options are checked here. Run closes the source readers, w is
+// left to the caller to close
+//------------------------------------------------------------------------------
+func New(sources []Source, opts Options, w vcfio.Writer) (*Merger, error) {
+	m := &Merger{sources: sources, opts: opts, w: w, log: opts.Log}
+	if m.log == nil {
+		m.log = log.New(io.Discard, "", 0)
+	}
+	if len(sources) == 0 {
+		return nil, errors.New("no inputs")
+	}
+	stdin_at := ""
+	for _, src := range sources {
+		switch {
+		case src.Assay == "":
+			return nil, fmt.Errorf("input %s has no assay name", src.Path)
+		case contains(m.assaytypeList, src.Assay):
+			return nil, fmt.Errorf("assay %s given twice", src.Assay)
+		case src.Reader == nil && src.Path == "":
+			return nil, fmt.Errorf("assay %s has no reader or path", src.Assay)
+		case src.Reader == nil && src.Path == "-" && stdin_at != "":
+			return nil, fmt.Errorf("%s and %s both read from stdin", stdin_at, src.Assay)
+		case src.Reader == nil && src.Path == "-":
+			stdin_at = src.Assay
+		}
+		m.assaytypeList = append(m.assaytypeList, src.Assay)
+	}
+	if m.opts.Chrom == "" {
+		m.opts.Chrom = WholeGenome
+	}
+	if m.opts.Resolver == "" {
+		m.opts.Resolver = vcfmerge.ResolvePosterior
+	}
+	if m.opts.Collide == "" {
+		m.opts.Collide = CollideMerge
+	}
+	if m.opts.Palindrome == "" {
+		m.opts.Palindrome = PalindromeDrop
+	}
+	if m.opts.SampleOrder == "" {
+		m.opts.SampleOrder = OrderAlpha
+	}
+	if m.opts.Unlisted == "" {
+		m.opts.Unlisted = UnlistedAppend
+	}
+	if m.opts.Collide != CollideMerge && m.opts.Collide != CollideSuffix {
+		return nil, fmt.Errorf("collide %s: expected %s or %s", m.opts.Collide, CollideMerge, CollideSuffix)
+	}
+	if m.opts.Palindrome != PalindromeDrop && m.opts.Palindrome != PalindromeAF {
+		return nil, fmt.Errorf("palindrome %s: expected %s or %s", m.opts.Palindrome, PalindromeDrop, PalindromeAF)
+	}
+	switch m.opts.SampleOrder {
+	case OrderAlpha, OrderAssay:
+	case OrderFile:
+		if len(m.opts.Order) == 0 {
+			return nil, fmt.Errorf("sample order %s: no sample order given", m.opts.SampleOrder)
+		}
+		if m.opts.Unlisted != UnlistedAppend && m.opts.Unlisted != UnlistedDrop {
+			return nil, fmt.Errorf("unlisted %s: expected %s or %s", m.opts.Unlisted, UnlistedAppend, UnlistedDrop)
+		}
+	default:
+		return nil, fmt.Errorf("sample order %s: expected %s, %s or %s", m.opts.SampleOrder, OrderAlpha, OrderAssay,
+			OrderFile)
+	}
+	for at := range m.opts.Rename {
+		if !contains(m.assaytypeList, at) {
+			return nil, fmt.Errorf("rename: no assay %s in the inputs", at)
+		}
+	}
+	for _, at := range m.assaytypeList {
+		if _, ok := m.opts.Manifest[at]; m.opts.Manifest != nil && !ok {
+			return nil, fmt.Errorf("manifest: no assay %s", at)
+		}
+	}
+	var err error
+	m.resolver, err = vcfmerge.NewResolver(m.opts.Resolver, m.opts.Manifest.ByPriority(m.assaytypeList), m.opts.Threshold)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+//------------------------------------------------------------------------------
+// Merge the sources, writing the header and merged records to the writer
+// and the reports to their writers, until every input is exhausted or ctx
+// is done
+//------------------------------------------------------------------------------
+func (m *Merger) Run(ctx context.Context) (*Metrics, error) {
+	if m.ran {
+		return nil, errors.New("merger has already run")
+	}
+	m.ran = true
+	m.readers = make(map[string]vcfio.Reader)
+	defer m.close()
+	if err := m.open(); err != nil {
+		return nil, err
+	}
+	m.log.Printf("Resolver: %s\n", m.resolver.Name())
+	if _, err := Build(m.opts.Manifest, m.assaytypeList); err != nil {
+		return nil, err
+	}
+	m.log_manifest()
+	m.get_params()
+	rejections := m.opts.Rejections
+	if rejections == nil {
+		rejections = io.Discard
+	}
+	m.rejWriter = report.NewRejectionWriter(rejections)
+
+	// handle file headers
+	headers := make(map[string][]string)
+	meta_headers := make(map[string][]string)
+	for assaytype, rdr := range m.readers {
+		meta_headers[assaytype], headers[assaytype] = rdr.MetaLines(), rdr.Samples()
+	}
+	m.assaySamples = headers
+	m.ploidyCache = make(map[string][]int)
+	m.contigs = get_merged_contigs(meta_headers, m.assaytypeList)
+	m.contigOrder = m.get_contig_order(m.contigs)
+	m.log.Printf("Contig order: %v\n", m.contigOrder)
+	regions, err := m.get_regions()
+	if err != nil {
+		return nil, err
+	}
+	if len(regions) > 0 {
+		m.log.Printf("Regions: %v\n", regions)
+		for _, src := range m.sources {
+			restricted, indexed, err := vcfio.Restrict(m.readers[src.Assay], src.Path, regions)
+			if err != nil {
+				return nil, err
+			}
+			m.log.Printf("Input %s: region restricted, indexed=%t\n", src.Assay, indexed)
+			m.readers[src.Assay] = restricted
+		}
+	}
+	for assaytype, rdr := range m.readers {
+		if m.readers[assaytype], err = m.select_samples(assaytype, rdr); err != nil {
+			return nil, err
+		}
+		headers[assaytype] = m.readers[assaytype].Samples()
+	}
+	var metrics Metrics
+	if m.opts.Identity != nil {
+		if metrics.Identity, err = m.check_sample_identity(ctx, headers, regions); err != nil {
+			return nil, err
+		}
+	}
+	if m.opts.Collide == CollideSuffix {
+		if err := m.suffix_colliding_samples(headers); err != nil {
+			return nil, err
+		}
+	}
+	if m.opts.SampleOrder == OrderFile && m.opts.Unlisted == UnlistedDrop {
+		if err := m.drop_unlisted_samples(headers); err != nil {
+			return nil, err
+		}
+	}
+	// Headers and combined header map
+	sample_name_map, sample_posn_map := sample.MakeSamplesByAssaytype(headers)
+	combocols := m.get_sample_order(sample_name_map)
+	colhdr_str, combo_names := vcfmerge.GetCombinedColumnHeaders(combocols)
+	header, conflicts, err := m.get_header(meta_headers)
+	if err != nil {
+		return nil, err
+	}
+	metrics.HeaderConflicts = conflicts
+	m.headerNumbers = header.Numbers()
+	if err := m.w.WriteHeader(append(header.Lines(), colhdr_str)); err != nil {
+		return nil, err
+	}
+
+	// read first records and capture keys (genomic position and alleles)
+	records := make(map[string][]string)
+	keys := make(map[string]variant.VarKey)
+	varids := make(map[string]string)
+	sreaders := make(map[string]*siteReader)
+	for assaytype, rdr := range m.readers {
+		sreaders[assaytype] = &siteReader{name: assaytype, rdr: rdr, order: m.contigOrder, last: variant.MaxKey}
+		if m.opts.Chrom != WholeGenome {
+			sreaders[assaytype].chrom = m.opts.Chrom
+		}
+		records[assaytype], keys[assaytype], varids[assaytype] = get_next_record_slice(sreaders[assaytype])
+	}
+	// process until all files exhausted
+	for records_remain(keys) {
+		if err := m.read_error(sreaders); err != nil {
+			return nil, err
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err := m.check_low_key_records(records, keys, varids, sreaders); err != nil {
+			return nil, err
+		}
+		if err := m.output_from_low_key_records(records, keys, sample_posn_map, combocols, combo_names,
+			&metrics.AllMetrics); err != nil {
+			return nil, err
+		}
+		metrics.Records += 1
+		records, keys, varids = m.read_from_low_key_records(records, keys, sreaders, varids)
+	}
+	if err := m.read_error(sreaders); err != nil {
+		return nil, err
+	}
+	if err := m.rejWriter.Flush(); err != nil {
+		return nil, err
+	}
+	metrics.Rejections = m.rejWriter.Count
+	if m.opts.Concordance != nil {
+		if err := m.write_concordance(metrics.Concordance); err != nil {
+			return nil, err
+		}
+	}
+	return &metrics, nil
+}
+
+//------------------------------------------------------------------------------
+// Open the sources without a reader
+//------------------------------------------------------------------------------
+func (m *Merger) open() error {
+	for _, src := range m.sources {
+		if src.Reader != nil {
+			m.readers[src.Assay] = src.Reader
+			continue
+		}
+		reader, format, err := vcfio.NewReader(src.Path)
+		if err != nil {
+			return err
+		}
+		m.log.Printf("Input %s: %s (%s)\n", src.Assay, src.Path, format)
+		m.readers[src.Assay] = reader
+	}
+	return nil
+}
+
+func (m *Merger) close() {
+	for _, rdr := range m.readers {
+		rdr.Close()
+	}
+}
+
+//-------------------------------------------------------------
+// The genome build the assays declare in the manifest, "" if none does,
+// a HeaderError if two declare different builds
+//-------------------------------------------------------------
+func Build(manifest assay.Manifest, assaytype_list []string) (string, error) {
+	build, build_at := "", ""
+	for _, at := range assaytype_list {
+		a := manifest.Get(at)
+		if a.Build == "" {
+			continue
+		}
+		if build != "" && !strings.EqualFold(build, a.Build) {
+			return "", &HeaderError{Assay: at, Other: build_at, Msg: fmt.Sprintf("build %s, not %s", a.Build, build)}
+		}
+		build, build_at = a.Build, at
+	}
+	return build, nil
+}
+
+//-------------------------------------------------------------
+// Log the manifest metadata of each assay
+//-------------------------------------------------------------
+func (m *Merger) log_manifest() {
+	for name := range m.opts.Manifest {
+		if !contains(m.assaytypeList, name) {
+			m.log.Printf("Manifest: assay %s is not in the template file\n", name)
+		}
+	}
+	for _, at := range m.assaytypeList {
+		a := m.opts.Manifest.Get(at)
+		m.log.Printf("Assay %s: abbrev=%s priority=%d build=%s panel=%s qc=%v\n", at, m.opts.Manifest.Abbrev(at),
+			a.Priority, a.Build, a.Panel, a.QC)
+		if m.opts.Manifest.Abbrev(at) == at {
+			m.log.Printf("Assay %s: no abbreviation, AT is the assay name\n", at)
+		}
+	}
+}
+
+// A manifest entry as a header meta line
+func (m *Merger) manifest_line(a *assay.Assay) string {
+	fields := []string{"ID=" + a.Name, "Abbrev=" + m.opts.Manifest.Abbrev(a.Name)}
+	if a.Priority > 0 {
+		fields = append(fields, fmt.Sprintf("Priority=%d", a.Priority))
+	}
+	if a.Build != "" {
+		fields = append(fields, "Build="+a.Build)
+	}
+	if a.Panel != "" {
+		fields = append(fields, "Panel="+a.Panel)
+	}
+	return "##assay=<" + strings.Join(fields, ",") + ">"
+}
+
+//-------------------------------------------------------------
+// QC parameters for each assay: the run's, with the assay's manifest
+// thresholds in place
+//-------------------------------------------------------------
+func (m *Merger) get_params() {
+	values := m.opts.Params
+	runParams := genometrics.GetRunParams(values[paramTestNum], values[paramMafDelta], values[paramCallRate],
+		values[paramInfoScore])
+	m.log.Printf("Params: %v\n", runParams)
+	m.assayParams = make(map[string]genometrics.RunParameters)
+	for _, at := range m.assaytypeList {
+		values := m.opts.Manifest.Params(at, m.opts.Params)
+		m.assayParams[at] = genometrics.GetRunParams(values[paramTestNum], values[paramMafDelta],
+			values[paramCallRate], values[paramInfoScore])
+		if m.assayParams[at] != runParams {
+			m.log.Printf("Params %s: %v\n", at, m.assayParams[at])
+		}
+	}
+}
+
+// Parameter file keys of Options.Params
+const (
+	paramTestNum   = "TESTNUM"
+	paramCallRate  = "CALLRATE"
+	paramMafDelta  = "MAFDELTA"
+	paramInfoScore = "INFOSCORE"
+)
+
+//-------------------------------------------------------------
+// An assay reader with its samples renamed and filtered
+//-------------------------------------------------------------
+func (m *Merger) select_samples(at string, rdr vcfio.Reader) (vcfio.Reader, error) {
+	rename := m.opts.Rename[at]
+	if rename == nil && m.opts.Keep == nil && m.opts.Remove == nil {
+		return rdr, nil
+	}
+	names, cols, err := sample.SelectSamples(rdr.Samples(), rename, m.opts.Keep, m.opts.Remove)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", at, err)
+	}
+	m.log.Printf("Samples %s: %d of %d kept, %d renamed\n", at, len(names), len(rdr.Samples()), len(rename))
+	return vcfio.SelectSamples(rdr, names, cols), nil
+}
+
+//-------------------------------------------------------------
+// Samples named in more than one assay renamed with the assay abbreviation
+// as a suffix, so each assay's data has its own column (sampleBase keeps the
+// name before suffixing)
+//-------------------------------------------------------------
+func (m *Merger) suffix_colliding_samples(headers map[string][]string) error {
+	in_assays := make(map[string]int)
+	for _, at := range m.assaytypeList {
+		for _, name := range headers[at] {
+			in_assays[name]++
+		}
+	}
+	m.sampleBase = make(map[string]string)
+	for _, at := range m.assaytypeList {
+		abbrev := m.opts.Manifest.Abbrev(at)
+		names := make([]string, len(headers[at]))
+		cols := make([]int, len(headers[at]))
+		suffixed := 0
+		for i, name := range headers[at] {
+			names[i], cols[i] = name, i
+			if in_assays[name] > 1 {
+				names[i] = name + "_" + abbrev
+				if _, ok := in_assays[names[i]]; ok {
+					return fmt.Errorf("%s: suffixed sample %s is already a sample name", at, names[i])
+				}
+				m.sampleBase[names[i]] = name
+				suffixed++
+			}
+		}
+		if suffixed > 0 {
+			m.log.Printf("Samples %s: %d suffixed _%s\n", at, suffixed, abbrev)
+			m.readers[at] = vcfio.SelectSamples(m.readers[at], names, cols)
+			headers[at] = names
+		}
+	}
+	return nil
+}
+
+//-------------------------------------------------------------
+// Samples not in the order left out of the assays
+//-------------------------------------------------------------
+func (m *Merger) drop_unlisted_samples(headers map[string][]string) error {
+	listed := make(map[string]bool, len(m.opts.Order))
+	for _, name := range m.opts.Order {
+		listed[name] = true
+	}
+	for at, rdr := range m.readers {
+		names, cols, err := sample.SelectSamples(headers[at], nil, listed, nil)
+		if err != nil {
+			return fmt.Errorf("%s: %v", at, err)
+		}
+		if len(names) < len(headers[at]) {
+			m.log.Printf("Samples %s: %d not in the order file dropped\n", at, len(headers[at])-len(names))
+			m.readers[at] = vcfio.SelectSamples(rdr, names, cols)
+			headers[at] = names
+		}
+	}
+	return nil
+}
+
+//-------------------------------------------------------------
+// Output sample column positions, by the sample order
+//-------------------------------------------------------------
+func (m *Merger) get_sample_order(sample_name_map map[string]map[string]int) map[string]int {
+	switch m.opts.SampleOrder {
+	case OrderAssay:
+		return sample.GetCombinedSampleMapByAssaytypes(sample_name_map, m.assaytypeList)
+	case OrderFile:
+		order := m.opts.Order
+		combocols := sample.GetCombinedSampleMapByOrder(sample_name_map, order, m.opts.Unlisted == UnlistedAppend)
+		if missing := len(order) - (len(combocols) - unlisted_count(combocols, order)); missing > 0 {
+			m.log.Printf("Sample order: %d listed samples are in no assay\n", missing)
+		}
+		return combocols
+	}
+	return sample.GetCombinedSampleMap(sample_name_map)
+}
+
+// Samples in combocols not in order
+func unlisted_count(combocols map[string]int, order []string) int {
+	count := len(combocols)
+	for _, name := range order {
+		if _, ok := combocols[name]; ok {
+			count--
+		}
+	}
+	return count
+}
+
+//-------------------------------------------------------------
+// Identity-by-state pre-pass: the inputs read again (restricted to windows
+// spread over the chromosome or regions of the merge) and sample pairs
+// flagged as swaps or duplicates written to the identity report
+//-------------------------------------------------------------
+func (m *Merger) check_sample_identity(ctx context.Context, headers map[string][]string,
+	regions []region.Region) ([]ibs.Pair, error) {
+	windows := m.identity_windows(regions, true)
+	collector := ibs.NewCollector(m.opts.Threshold, windows)
+	assays := make([]string, 0, len(m.sources))
+	for _, src := range m.sources {
+		at := src.Assay
+		if src.Path == "" || src.Path == "-" {
+			m.log.Printf("Identity check: %s cannot be read again, left out\n", at)
+			continue
+		}
+		if err := m.collect_identity(ctx, collector, src, windows); err != nil {
+			return nil, err
+		}
+		// an input covering only part of its contigs misses most windows,
+		// its sites are then taken from whole contigs
+		if whole := m.identity_windows(regions, false); len(assays) == 0 && collector.Sites() < ibs.MinSites &&
+			len(whole) != len(windows) {
+			m.log.Printf("Identity check: %d sites of %s in %d windows, sampled by contig\n", collector.Sites(),
+				at, len(windows))
+			windows = whole
+			collector = ibs.NewCollector(m.opts.Threshold, windows)
+			if err := m.collect_identity(ctx, collector, src, windows); err != nil {
+				return nil, err
+			}
+		}
+		assays = append(assays, at)
+	}
+	m.log.Printf("Identity check: %d sites over %d windows\n", collector.Sites(), len(windows))
+	pairs := collector.Compare(assays, headers, m.opts.IbsMin)
+	flagged := make(map[string]int)
+	for _, pair := range pairs {
+		if pair.Flag != ibs.FlagMatch {
+			flagged[pair.Flag]++
+			m.log.Printf("Identity check: %s %s:%s %s:%s IBS=%.4f over %d sites\n", pair.Flag, pair.Assay1,
+				pair.Sample1, pair.Assay2, pair.Sample2, pair.IBS, pair.Sites)
+		}
+	}
+	if err := ibs.WritePairs(m.opts.Identity, pairs); err != nil {
+		return nil, err
+	}
+	m.log.Printf("Identity check: %d pairs reported, flagged %v\n", len(pairs), flagged)
+	return pairs, nil
+}
+
+// An input's records in the windows added to the collector, read until the
+// collector has all it needs from the input
+func (m *Merger) collect_identity(ctx context.Context, collector *ibs.Collector, src Source,
+	windows []region.Region) error {
+	rdr, _, err := vcfio.NewReader(src.Path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		rdr.Close()
+	}()
+	if len(windows) > 0 {
+		if rdr, _, err = vcfio.Restrict(rdr, src.Path, windows); err != nil {
+			return err
+		}
+	}
+	if rdr, err = m.select_samples(src.Assay, rdr); err != nil {
+		return err
+	}
+	for !collector.Done(src.Assay) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rec, err := rdr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return reader_error(src.Assay, err)
+		}
+		if m.opts.Chrom == WholeGenome || variant.SameChrom(variant.GetChrom(rec), m.opts.Chrom) {
+			collector.Add(src.Assay, rec)
+		}
+	}
+	return nil
+}
+
+//-------------------------------------------------------------
+// Windows the identity sites are sampled from: the regions of the merge,
+// otherwise (if spread) ibs.Windows windows spaced evenly along the contigs
+// in scope, a contig without a ##contig length is one window
+//-------------------------------------------------------------
+func (m *Merger) identity_windows(regions []region.Region, spread bool) []region.Region {
+	if len(regions) > 0 {
+		return regions
+	}
+	windows := make([]region.Region, 0, ibs.Windows)
+	total := int64(0)
+	for _, contig := range m.contigs {
+		if m.opts.Chrom == WholeGenome || variant.SameChrom(contig.ID, m.opts.Chrom) {
+			total += contig.Length
+		}
+	}
+	step := total / ibs.Windows
+	next := step / 2
+	for _, contig := range m.contigs {
+		if m.opts.Chrom != WholeGenome && !variant.SameChrom(contig.ID, m.opts.Chrom) {
+			continue
+		}
+		if !spread || contig.Length == 0 || step <= ibs.WindowSize {
+			windows = append(windows, region.Region{Chrom: contig.ID, Beg: 0, End: variant.MaxPosn})
+			continue
+		}
+		for ; next < contig.Length; next += step {
+			end := next + ibs.WindowSize
+			if end > contig.Length {
+				end = contig.Length
+			}
+			windows = append(windows, region.Region{Chrom: contig.ID, Beg: next, End: end})
+		}
+		next -= contig.Length
+	}
+	return region.Normalise(windows, m.contigOrder)
+}
+
+//-------------------------------------------------------------
+// The per-sample concordance report, by sample then assay pair
+//-------------------------------------------------------------
+func (m *Merger) write_concordance(concordance map[genometrics.ConcordanceKey]*genometrics.ConcordanceCounts) error {
+	keys := make([]genometrics.ConcordanceKey, 0, len(concordance))
+	for key := range concordance {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Sample != keys[j].Sample {
+			return keys[i].Sample < keys[j].Sample
+		}
+		if keys[i].Assay1 != keys[j].Assay1 {
+			return keys[i].Assay1 < keys[j].Assay1
+		}
+		return keys[i].Assay2 < keys[j].Assay2
+	})
+	cw := report.NewConcordanceWriter(m.opts.Concordance)
+	for _, key := range keys {
+		cc := concordance[key]
+		cw.Write(report.Concordance{Sample: key.Sample, Assay1: key.Assay1, Assay2: key.Assay2, Compared: cc.Compared,
+			Concordant: cc.Concordant, Discordant: cc.Discordant, Missing1: cc.Missing1, Missing2: cc.Missing2})
+	}
+	if err := cw.Flush(); err != nil {
+		return err
+	}
+	m.log.Printf("Concordance report: %d sample/assay pairs\n", len(keys))
+	return nil
+}
+
+//-------------------------------------------------------------
+// Options.Regions sorted into contig order and merged, those off the
+// chromosome being merged (unless all) are dropped
+//-------------------------------------------------------------
+func (m *Merger) get_regions() ([]region.Region, error) {
+	if len(m.opts.Regions) == 0 {
+		return nil, nil
+	}
+	kept := make([]region.Region, 0, len(m.opts.Regions))
+	for _, reg := range m.opts.Regions {
+		if m.opts.Chrom == WholeGenome || variant.SameChrom(reg.Chrom, m.opts.Chrom) {
+			kept = append(kept, reg)
+		} else {
+			m.log.Printf("Region %v is not on chromosome %s, skipped\n", reg, m.opts.Chrom)
+		}
+	}
+	if len(kept) == 0 {
+		return nil, fmt.Errorf("no regions on chromosome %s", m.opts.Chrom)
+	}
+	return region.Normalise(kept, m.contigOrder), nil
+}
+
+//-------------------------------------------------------------
+// The input ##contig lines combined in template order
+//-------------------------------------------------------------
+func get_merged_contigs(meta_headers map[string][]string, assaytype_list []string) []vcfheader.Contig {
+	contig_lists := make([][]vcfheader.Contig, 0, len(assaytype_list))
+	for _, at := range assaytype_list {
+		contig_lists = append(contig_lists, vcfheader.GetContigs(meta_headers[at]))
+	}
+	return vcfheader.MergeContigLists(contig_lists)
+}
+
+//-------------------------------------------------------------
+// Contig order from Options.Contigs, otherwise from the input ##contig lines
+//-------------------------------------------------------------
+func (m *Merger) get_contig_order(contigs []vcfheader.Contig) variant.ContigOrder {
+	if len(m.opts.Contigs) > 0 {
+		return variant.MakeContigOrder(m.opts.Contigs)
+	}
+	names := make([]string, 0, len(contigs))
+	for _, contig := range contigs {
+		names = append(names, contig.ID)
+	}
+	return variant.MakeContigOrder(names)
+}
+
+//-------------------------------------------------------------
+// siteReader: holds every record at the current site (CHROM, POS)
+// of a single input, ordered by composite key
+// If chrom is set records on other chromosomes are skipped, the first
+// read error (or unsorted site) is held in err and ends the input
+//-------------------------------------------------------------
+type siteReader struct {
+	name  string
+	rdr   vcfio.Reader
+	order variant.ContigOrder
+	chrom string
+	site  [][]string
+	ahead []string
+	last  variant.VarKey
+	err   error
+}
+
+//-------------------------------------------------------------
+// Read a record from a single reader and split it to format a string slice
+//-------------------------------------------------------------
+func (sr *siteReader) read_record_slice() []string {
+	for sr.err == nil {
+		data, err := sr.rdr.Read()
+		if err == io.EOF {
+			return empty_record
+		}
+		if err != nil {
+			sr.err = reader_error(sr.name, err)
+			break
+		}
+		if sr.chrom == "" || variant.SameChrom(variant.GetChrom(data), sr.chrom) {
+			return data
+		}
+	}
+	return empty_record
+}
+
+// A read error naming the assay, parse errors name their file already
+func reader_error(at string, err error) error {
+	var perr *vcfio.ParseError
+	if errors.As(err, &perr) {
+		return err
+	}
+	return fmt.Errorf("%s: %w", at, err)
+}
+
+//-------------------------------------------------------------
+// Collect all records sharing the CHROM and POS of the next record,
+// sorted by REF and ALT, records with the same key keep file order
+//-------------------------------------------------------------
+func (sr *siteReader) fill_site() {
+	first := sr.ahead
+	if len(first) == 0 {
+		first = sr.read_record_slice()
+	}
+	sr.ahead = empty_record
+	if len(first) == 0 {
+		return
+	}
+	site_key := variant.VarKey{Chrom: variant.GetKey(first).Chrom, Posn: variant.GetPosn(first)}
+	if !sr.last.IsMax() && sr.order.Compare(site_key, sr.last) <= 0 {
+		sr.err = &UnsortedError{Assay: sr.name, Chrom: variant.GetChrom(first), Posn: site_key.Posn}
+		return
+	}
+	sr.last = site_key
+	sr.site = append(sr.site, first)
+	for {
+		data := sr.read_record_slice()
+		if len(data) == 0 {
+			break
+		}
+		if variant.GetChrom(data) != variant.GetChrom(first) || variant.GetPosn(data) != variant.GetPosn(first) {
+			sr.ahead = data
+			break
+		}
+		sr.site = append(sr.site, data)
+	}
+	sort.SliceStable(sr.site, func(i, j int) bool {
+		return sr.order.Compare(variant.GetKey(sr.site[i]), variant.GetKey(sr.site[j])) < 0
+	})
+}
+
+//-------------------------------------------------------------
+// Take the next record (in key order) from a site reader
+//-------------------------------------------------------------
+func get_next_record_slice(sr *siteReader) ([]string, variant.VarKey, string) {
+	if len(sr.site) == 0 {
+		sr.fill_site()
+	}
+	if len(sr.site) == 0 {
+		return empty_record, variant.MaxKey, ""
+	}
+	data := sr.site[0]
+	sr.site = sr.site[1:]
+	return data, variant.GetKey(data), variant.GetVarid(data)
+}
+
+//-------------------------------------------------------------
+// The first error held by the site readers, in template order
+//-------------------------------------------------------------
+func (m *Merger) read_error(sreaders map[string]*siteReader) error {
+	for _, at := range m.assaytypeList {
+		if err := sreaders[at].err; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//-------------------------------------------------------------
+// If all keys are high there are no records to be read
+//-------------------------------------------------------------
+func records_remain(keys map[string]variant.VarKey) bool {
+	for _, key := range keys {
+		if !key.IsMax() {
+			return true
+		}
+	}
+	return false
+}
+
+//-------------------------------------------------------------
+// Cross check low key records to match alleles (when there
+// are more than one)
+// This is done once per site, for every record of every assay at the
+// site, so that harmonised records take their place in key order (the
+// record maps are updated in place)
+//-------------------------------------------------------------
+func (m *Merger) check_low_key_records(records map[string][]string, keys map[string]variant.VarKey,
+	varids map[string]string, sreaders map[string]*siteReader) error {
+	low_keys := m.get_low_keys(keys)
+	site := variant.MaxKey
+	for _, key := range low_keys {
+		site = variant.VarKey{Chrom: key.Chrom, Posn: key.Posn}
+	}
+	if site.IsMax() || site == m.checkedSite {
+		return nil
+	}
+	m.checkedSite = site
+
+	accepted := make([]siteAlleles, 0)
+	blocks := make(map[string][][]string)
+	for _, at := range m.assaytypeList {
+		if keys[at].IsMax() || keys[at].Chrom != site.Chrom || keys[at].Posn != site.Posn {
+			continue
+		}
+		block := append([][]string{records[at]}, sreaders[at].site...)
+		kept := make([][]string, 0, len(block))
+		for _, rec := range block {
+			if rec, ok := m.harmonise_alleles(at, rec, accepted); ok {
+				kept = append(kept, rec)
+			}
+		}
+		for _, rec := range kept {
+			ref, alt := variant.GetAlleles(rec)
+			af, has_af := variant.GetAltFreq(rec)
+			accepted = append(accepted, siteAlleles{assaytype: at, ref: ref, alt: alt, af: af, has_af: has_af})
+		}
+		blocks[at] = kept
+	}
+	if err := m.unify_alt_lists(blocks); err != nil {
+		return err
+	}
+	for _, at := range m.assaytypeList {
+		kept, ok := blocks[at]
+		if !ok {
+			continue
+		}
+		sort.SliceStable(kept, func(i, j int) bool {
+			return m.contigOrder.Compare(variant.GetKey(kept[i]), variant.GetKey(kept[j])) < 0
+		})
+		sr := sreaders[at]
+		sr.site = kept
+		records[at], keys[at], varids[at] = get_next_record_slice(sr)
+	}
+	return nil
+}
+
+//-------------------------------------------------------------
+// Records of different assays at a site with the same REF and
+// overlapping ALT lists (e.g. A>G and A>G,T) are rewritten to the union
+// of their ALT lists (in template order), so they share a key and merge
+//-------------------------------------------------------------
+func (m *Merger) unify_alt_lists(blocks map[string][][]string) error {
+	type altGroup struct {
+		ref     string
+		alts    []string
+		members map[string][]int
+	}
+	groups := make([]*altGroup, 0)
+	for _, at := range m.assaytypeList {
+		for i, rec := range blocks[at] {
+			ref, alt := variant.GetAlleles(rec)
+			alts := strings.Split(alt, ",")
+			var group *altGroup
+			for _, g := range groups {
+				if _, seen := g.members[at]; !seen && g.ref == ref && overlaps(g.alts, alts) {
+					group = g
+					break
+				}
+			}
+			if group == nil {
+				group = &altGroup{ref: ref, members: make(map[string][]int)}
+				groups = append(groups, group)
+			}
+			for _, a := range alts {
+				if !contains(group.alts, a) {
+					group.alts = append(group.alts, a)
+				}
+			}
+			group.members[at] = append(group.members[at], i)
+		}
+	}
+	for _, group := range groups {
+		for _, at := range m.assaytypeList {
+			for _, i := range group.members[at] {
+				rec := blocks[at][i]
+				ref, alt := variant.GetAlleles(rec)
+				if alt == strings.Join(group.alts, ",") {
+					continue
+				}
+				rec, err := variant.RemapAlleles(rec, group.alts, m.headerNumbers)
+				if err != nil {
+					return fmt.Errorf("%s: %s:%d: %v", at, variant.GetChrom(rec), variant.GetPosn(rec), err)
+				}
+				blocks[at][i] = rec
+				m.rejWriter.Write(report.Rejection{Chrom: variant.GetChrom(rec), Posn: variant.GetPosn(rec),
+					Varid: variant.GetVarid(rec), Assaytype: at, Ref: ref, Alt: alt, Action: report.ActionRemap,
+					Reason: fmt.Sprintf("ALT %s remapped to %s", alt, strings.Join(group.alts, ","))})
+			}
+		}
+	}
+	return nil
+}
+
+func overlaps(a []string, b []string) bool {
+	for _, elem := range b {
+		if contains(a, elem) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, elem string) bool {
+	for _, l := range list {
+		if l == elem {
+			return true
+		}
+	}
+	return false
+}
+
+//-------------------------------------------------------------
+// siteAlleles: alleles of a record already accepted at a site, and its ALT
+// allele frequency if the record has one
+//-------------------------------------------------------------
+type siteAlleles struct {
+	assaytype string
+	ref       string
+	alt       string
+	af        float64
+	has_af    bool
+}
+
+//-------------------------------------------------------------
+// Match a record's alleles against those accepted from other assays:
+// exact matches and distinct alleles with a compatible REF are kept,
+// swaps and strand flips are harmonised, incompatible REFs dropped, and
+// palindromic alleles against their reverse dropped unless resolved
+//-------------------------------------------------------------
+func (m *Merger) harmonise_alleles(at string, rec []string, accepted []siteAlleles) ([]string, bool) {
+	ref, alt := variant.GetAlleles(rec)
+	for _, acc := range accepted {
+		if variant.MatchAlleles(ref, alt, acc.ref, acc.alt) == variant.AllelesMatch {
+			return rec, true
+		}
+	}
+	rej := report.Rejection{Chrom: variant.GetChrom(rec), Posn: variant.GetPosn(rec),
+		Varid: variant.GetVarid(rec), Assaytype: at, Ref: ref, Alt: alt}
+	for _, acc := range accepted {
+		match := variant.MatchAlleles(ref, alt, acc.ref, acc.alt)
+		reason := fmt.Sprintf("%s/%s harmonised to %s/%s of %s", ref, alt, acc.ref, acc.alt, acc.assaytype)
+		if match == variant.AllelesAmbiguous {
+			if match = m.resolve_palindrome(rec, acc); match == variant.AllelesNone {
+				rej.Action = report.ActionDrop
+				rej.Reason = fmt.Sprintf("palindromic %s/%s against %s/%s of %s, strand ambiguous", ref, alt,
+					acc.ref, acc.alt, acc.assaytype)
+				m.rejWriter.Write(rej)
+				return rec, false
+			}
+			reason += ", palindromic alleles resolved by allele frequency"
+		}
+		switch match {
+		case variant.AllelesSwap:
+			rej.Action = report.ActionSwap
+			rec = variant.SwapAlleles(rec)
+		case variant.AllelesFlip:
+			rej.Action = report.ActionFlip
+			rec = variant.FlipStrand(rec)
+		case variant.AllelesFlipSwap:
+			rej.Action = report.ActionFlipSwap
+			rec = variant.SwapAlleles(variant.FlipStrand(rec))
+		default:
+			continue
+		}
+		rej.Reason = reason
+		m.rejWriter.Write(rej)
+		return rec, true
+	}
+	for _, acc := range accepted {
+		if variant.RefsCompatible(ref, acc.ref) {
+			return rec, true
+		}
+	}
+	if len(accepted) == 0 {
+		return rec, true
+	}
+	rej.Action = report.ActionDrop
+	rej.Reason = fmt.Sprintf("REF %s incompatible with %s/%s of %s", ref, accepted[0].ref, accepted[0].alt, accepted[0].assaytype)
+	m.rejWriter.Write(rej)
+	return rec, false
+}
+
+//-------------------------------------------------------------
+// Palindromic alleles against their reverse, with PalindromeAF: a swap when
+// the record's ALT frequency is nearer 1-AF of the accepted record, a strand
+// flip when nearer its AF; AllelesNone (drop) otherwise, or when either
+// frequency is missing or either MAF is above palindromeMaxMaf
+//-------------------------------------------------------------
+func (m *Merger) resolve_palindrome(rec []string, acc siteAlleles) string {
+	af, ok := variant.GetAltFreq(rec)
+	if m.opts.Palindrome != PalindromeAF || !ok || !acc.has_af {
+		return variant.AllelesNone
+	}
+	if math.Min(af, 1.0-af) > palindromeMaxMaf || math.Min(acc.af, 1.0-acc.af) > palindromeMaxMaf {
+		return variant.AllelesNone
+	}
+	if math.Abs(af-(1.0-acc.af)) < math.Abs(af-acc.af) {
+		return variant.AllelesSwap
+	}
+	return variant.AllelesFlip
+}
+
+func (m *Merger) output_from_low_key_records(records map[string][]string, keys map[string]variant.VarKey,
+	sample_posn_map map[string]map[int]string,
+	combocols map[string]int, combo_names []string, genomet *genometrics.AllMetrics) error {
+	//
+	low_keys := m.get_low_keys(keys)
+	// template order, as the priority resolver and the combined columns
+	low_key_at := make([]string, 0, len(low_keys))
+	for _, at := range m.assaytypeList {
+		if _, ok := low_keys[at]; ok {
+			low_key_at = append(low_key_at, at)
+		}
+	}
+
+	vcfrecords := make([][]string, 0, len(records))
+	vcfd := make([]vcfmerge.Vcfdata, 0, len(records))
+	rsid := ""
+	for _, at := range low_key_at {
+		if rsid == "" || rsid == "." {
+			rsid = variant.GetVarid(records[at])
+		}
+		rec := make([]string, 1, len(records[at])+1)
+		rec[0] = at
+		rec = append(rec, records[at]...)
+		vcfrecords = append(vcfrecords, rec)
+		vcfd = append(vcfd, m.qc_assay_record(at, records[at]))
+	}
+	ploidies := m.get_ploidies("", combo_names, vcfrecords[0][1:])
+	comborec := vcfmerge.Mergeslices_full(vcfrecords, vcfd, rsid, sample_posn_map, combocols, combo_names,
+		m.opts.Threshold, m.resolver, ploidies, genomet)
+	return m.w.WriteRecord(comborec)
+}
+
+//-------------------------------------------------------------
+// QC data for an assay record, failures go to the rejection report
+//-------------------------------------------------------------
+func (m *Merger) qc_assay_record(at string, rec []string) vcfmerge.Vcfdata {
+	cr, infoscore, filters := genometrics.QCRecord(rec, m.opts.Threshold, m.assayParams[at],
+		m.get_ploidies(at, m.assaySamples[at], rec))
+	if len(filters) > 0 {
+		ref, alt := variant.GetAlleles(rec)
+		m.rejWriter.Write(report.Rejection{Chrom: variant.GetChrom(rec), Posn: variant.GetPosn(rec),
+			Varid: variant.GetVarid(rec), Assaytype: at, Ref: ref, Alt: alt, Action: report.ActionFilter,
+			Reason: fmt.Sprintf("%s (callrate=%.4f, info=%.4f)", strings.Join(filters, ";"), cr, infoscore)})
+	}
+	return vcfmerge.Vcfdata{Assaytype: at, Abbrev: m.opts.Manifest.Abbrev(at), Probidx: variant.GetProbidx(rec),
+		Callrate: cr, Infoscore: infoscore, Filters: filters}
+}
+
+//-------------------------------------------------------------
+// Sample ploidies at the position of rec, nil (all diploid) without a
+// ploidy model, cached per sample list (an assay, or "" for the output) and zone
+//-------------------------------------------------------------
+func (m *Merger) get_ploidies(name string, samples []string, rec []string) []int {
+	if m.opts.Ploidy == nil {
+		return nil
+	}
+	zone := m.opts.Ploidy.Zone(variant.GetChrom(rec), variant.GetPosn(rec))
+	key := name + "\t" + zone
+	ploidies, ok := m.ploidyCache[key]
+	if !ok {
+		// sexes are given for the sample names before any suffix
+		names := make([]string, len(samples))
+		for i, name := range samples {
+			names[i] = name
+			if base, ok := m.sampleBase[name]; ok {
+				names[i] = base
+			}
+		}
+		ploidies = m.opts.Ploidy.Ploidies(zone, names)
+		m.ploidyCache[key] = ploidies
+	}
+	return ploidies
+}
+
+func (m *Merger) read_from_low_key_records(records map[string][]string, keys map[string]variant.VarKey, rdrs map[string]*siteReader, varids map[string]string) (map[string][]string, map[string]variant.VarKey, map[string]string) {
+	low_keys := m.get_low_keys(keys)
+	for assaytype, _ := range low_keys {
+		records[assaytype], keys[assaytype], varids[assaytype] = get_next_record_slice(rdrs[assaytype])
+	}
+	return records, keys, varids
+}
+
+//-------------------------------------------------------------
+// Assaytypes whose current record has the lowest composite key
+//-------------------------------------------------------------
+func (m *Merger) get_low_keys(keys map[string]variant.VarKey) map[string]variant.VarKey {
+	low_keys := make(map[string]variant.VarKey)
+	low_key := variant.MaxKey
+	for _, key := range keys {
+		if m.contigOrder.Compare(key, low_key) < 0 {
+			low_key = key
+		}
+	}
+	if !low_key.IsMax() {
+		for at, key := range keys {
+			if key == low_key {
+				low_keys[at] = key
+			}
+		}
+	}
+	return low_keys
+}
+
+//-------------------------------------------------------------
+// Output header: definitions for the fields the merge writes, then the
+// input meta lines unioned in template order (contigs named as in the
+// records) and the Options.Contigs not declared, then the manifest
+// entries and the merge run provenance
+// A conflicting input definition is logged and returned, the merge's own
+// (or the first input's) kept, or with StrictHeader is a HeaderError
+//-------------------------------------------------------------
+func (m *Merger) get_header(meta_headers map[string][]string) (*vcfheader.Header, []vcfheader.Conflict, error) {
+	hdr := vcfheader.NewHeader("VCFv4.2")
+	for _, line := range []string{
+		"##INFO=<ID=AC,Number=A,Type=Integer,Description=\"Allele count in genotypes\">",
+		"##INFO=<ID=AN,Number=1,Type=Integer,Description=\"Total number of alleles in called genotypes\">",
+		"##FORMAT=<ID=GT,Number=1,Type=String,Description=\"Genotype\">",
+		"##INFO=<ID=RefPanelAF,Number=A,Type=Float,Description=\"Allele frequency in imputation reference panel\">",
+		"##FORMAT=<ID=DS,Number=A,Type=Float,Description=\"Genotype dosage\">",
+		"##FORMAT=<ID=GP,Number=G,Type=Float,Description=\"Genotype posterior probabilities\">",
+		"##FORMAT=<ID=AT,Number=1,Type=String,Description=\"Assay Type\">",
+		"##INFO=<ID=TYPED,Number=0,Type=Flag,Description=\"Typed in input data\">",
+		"##FILTER=<ID=PASS,Description=\"All filters passed\">",
+		"##FILTER=<ID=" + genometrics.FilterCallRate + ",Description=\"Assay record excluded, call rate below CALLRATE\">",
+		"##FILTER=<ID=" + genometrics.FilterInfoScore + ",Description=\"Assay record excluded, INFO score below INFOSCORE\">",
+		"##FILTER=<ID=" + genometrics.FilterMafDelta + ",Description=\"Assay record excluded, MAF differs from RefPanelAF by more than MAFDELTA\">",
+	} {
+		hdr.Add(line, "")
+	}
+	for _, at := range m.assaytypeList {
+		for _, line := range meta_headers[at] {
+			hdr.Add(output_contig_line(line), at)
+		}
+	}
+	for _, name := range m.opts.Contigs {
+		if _, ok := hdr.Get("contig", variant.KeyChrom(name)); !ok {
+			hdr.Add("##contig=<ID="+variant.KeyChrom(name)+">", "")
+		}
+	}
+	conflicts := make([]vcfheader.Conflict, 0)
+	for _, conflict := range hdr.Conflicts {
+		if conflict = compatible_numbers(conflict); len(conflict.Fields) == 0 {
+			continue
+		}
+		if m.opts.StrictHeader {
+			other := conflict.Held.Source
+			if other == "" {
+				other = "merge"
+			}
+			return nil, nil, &HeaderError{Assay: conflict.Other.Source, Other: other, Msg: conflict.String()}
+		}
+		m.log.Printf("Header conflict: %v\n", conflict)
+		conflicts = append(conflicts, conflict)
+	}
+	for _, at := range m.assaytypeList {
+		if a, ok := m.opts.Manifest[at]; ok {
+			hdr.Add(m.manifest_line(a), "")
+		}
+	}
+	hdr.Add("##source=filemergevcf", "")
+	for _, line := range m.opts.HeaderLines {
+		hdr.Add(line, "")
+	}
+	return hdr, conflicts, nil
+}
+
+// A ##contig line with its ID as the merged records name the contig (no
+// leading zero), other lines as they are
+func output_contig_line(line string) string {
+	key, _, fields := vcfheader.ParseMetaLine(line)
+	if id := fields["ID"]; key == "contig" && variant.KeyChrom(id) != id {
+		return strings.Replace(line, "ID="+id, "ID="+variant.KeyChrom(id), 1)
+	}
+	return line
+}
+
+// Number=1 and Number=A agree for biallelic sites (as Minimac4 declares
+// DS), so a conflict in Number alone between them is dropped
+func compatible_numbers(conflict vcfheader.Conflict) vcfheader.Conflict {
+	numbers := []string{conflict.Held.Fields["Number"], conflict.Other.Fields["Number"]}
+	sort.Strings(numbers)
+	if numbers[0] != "1" || numbers[1] != "A" {
+		return conflict
+	}
+	fields := make([]string, 0, len(conflict.Fields))
+	for _, field := range conflict.Fields {
+		if field != "Number" {
+			fields = append(fields, field)
+		}
+	}
+	conflict.Fields = fields
+	return conflict
+}
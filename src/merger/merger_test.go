@@ -0,0 +1,396 @@
+package merger
+
+import (
+	"assay"
+	"context"
+	"errors"
+	"ibs"
+	"io"
+	"path/filepath"
+	"region"
+	"report"
+	"strings"
+	"testing"
+	"variant"
+	"vcfheader"
+	"vcfio"
+)
+
+const testMeta = "##fileformat=VCFv4.2\n" +
+	"##contig=<ID=1,length=1000>\n" +
+	"##FORMAT=<ID=GT,Number=1,Type=String,Description=\"Genotype\">\n" +
+	"##FORMAT=<ID=GP,Number=G,Type=Float,Description=\"Genotype probabilities\">\n"
+
+// A VCF of the test header lines, samples and tab separated records
+func test_vcf(samples string, records ...string) string {
+	vcf := testMeta + "#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\tFORMAT\t" + samples + "\n"
+	for _, rec := range records {
+		vcf += strings.Replace(rec, " ", "\t", -1) + "\n"
+	}
+	return vcf
+}
+
+//-----------------------------------------------
+// memWriter: the header and records written, in memory
+//-----------------------------------------------
+type memWriter struct {
+	header  []string
+	records [][]string
+}
+
+func (w *memWriter) WriteHeader(lines []string) error {
+	w.header = append([]string(nil), lines...)
+	return nil
+}
+
+func (w *memWriter) WriteRecord(rec []string) error {
+	w.records = append(w.records, append([]string(nil), rec...))
+	return nil
+}
+
+func (w *memWriter) Close() error {
+	return nil
+}
+
+// Sources of in-memory VCFs, by assay name then VCF text in pairs
+func test_sources(t *testing.T, inputs ...string) []Source {
+	sources := make([]Source, 0)
+	for i := 0; i+1 < len(inputs); i += 2 {
+		rdr, _, err := vcfio.NewStreamReader(io.NopCloser(strings.NewReader(inputs[i+1])), inputs[i]+".vcf")
+		if err != nil {
+			t.Fatalf("%s: %v", inputs[i], err)
+		}
+		sources = append(sources, Source{Assay: inputs[i], Path: inputs[i] + ".vcf", Reader: rdr})
+	}
+	return sources
+}
+
+func run_merge(t *testing.T, ctx context.Context, opts Options, inputs ...string) (*memWriter, *Metrics, error) {
+	w := &memWriter{}
+	m, err := New(test_sources(t, inputs...), opts, w)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	metrics, err := m.Run(ctx)
+	return w, metrics, err
+}
+
+func TestRunMergesRecords(t *testing.T) {
+	w, metrics, err := run_merge(t, context.Background(), Options{Threshold: 0.9},
+		"a", test_vcf("S1\tS2",
+			"1 100 rs1 A G . PASS . GT:GP 0/1:0,1,0 0/0:1,0,0",
+			"1 200 rs2 C T . PASS . GT:GP 1/1:0,0,1 0/1:0,1,0"),
+		"b", test_vcf("S2\tS3",
+			"1 100 rs1 A G . PASS . GT:GP 0/1:0.05,0.95,0 1/1:0,0,1",
+			"1 300 rs3 G A . PASS . GT:GP 0/0:1,0,0 0/1:0,1,0"))
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if got := w.header[len(w.header)-1]; got != "#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\tFORMAT\tS1\tS2\tS3" {
+		t.Errorf("column header %q", got)
+	}
+	want := []string{
+		"1 100 rs1 A G . PASS . GT:DS:GP:AT 0/1:1.000:0,1,0:a 0/0:0.000:1,0,0:a 1/1:2.000:0,0,1:b",
+		"1 200 rs2 C T . PASS . GT:DS:GP:AT 1/1:2.000:0,0,1:a 0/1:1.000:0,1,0:a ./.:.:.:.",
+		"1 300 rs3 G A . PASS . GT:DS:GP:AT ./.:.:.:. 0/0:0.000:1,0,0:b 0/1:1.000:0,1,0:b",
+	}
+	if len(w.records) != len(want) {
+		t.Fatalf("%d records written, want %d", len(w.records), len(want))
+	}
+	for i, rec := range w.records {
+		if got := strings.Join(rec, " "); got != want[i] {
+			t.Errorf("record %d:\n got %s\nwant %s", i, got, want[i])
+		}
+	}
+	// S2 at 1:100 is the one sample typed twice, its calls differ
+	if metrics.Records != 3 || metrics.AllGenoCount != 8 || metrics.UniqueGenoCount != 9 ||
+		metrics.OverlapTestCount != 1 || metrics.TwoOverlapCount != 1 || metrics.MismatchCount != 1 ||
+		metrics.MissingCount != 0 {
+		t.Errorf("metrics %+v", *metrics)
+	}
+}
+
+func TestRunUnsorted(t *testing.T) {
+	_, _, err := run_merge(t, context.Background(), Options{Threshold: 0.9},
+		"a", test_vcf("S1",
+			"1 200 rs2 C T . PASS . GT:GP 0/1:0,1,0",
+			"1 100 rs1 A G . PASS . GT:GP 0/1:0,1,0"),
+		"b", test_vcf("S2",
+			"1 100 rs1 A G . PASS . GT:GP 0/1:0,1,0"))
+	var unsorted *UnsortedError
+	if !errors.As(err, &unsorted) {
+		t.Fatalf("error %v, want an UnsortedError", err)
+	}
+	if unsorted.Assay != "a" || unsorted.Chrom != "1" || unsorted.Posn != 100 {
+		t.Errorf("unsorted at %+v", *unsorted)
+	}
+}
+
+func TestRunHeaderConflict(t *testing.T) {
+	records := "1 100 rs1 A G . PASS . GT:GP 0/1:0,1,0"
+	// GP with a Number other than the merge writes, as Minimac4 declares it
+	gp3 := strings.Replace(test_vcf("S2", records), "ID=GP,Number=G", "ID=GP,Number=3", 1)
+	w, metrics, err := run_merge(t, context.Background(), Options{Threshold: 0.9},
+		"a", test_vcf("S1", records), "b", gp3)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if len(metrics.HeaderConflicts) != 1 || metrics.HeaderConflicts[0].ID != "GP" ||
+		metrics.HeaderConflicts[0].Other.Source != "b" {
+		t.Errorf("header conflicts %v, want GP from b", metrics.HeaderConflicts)
+	}
+	if !has_line(w.header, "##FORMAT=<ID=GP,Number=G,") {
+		t.Errorf("merge GP definition not written: %v", w.header)
+	}
+	_, _, err = run_merge(t, context.Background(), Options{Threshold: 0.9, StrictHeader: true},
+		"a", test_vcf("S1", records), "b", gp3)
+	var herr *HeaderError
+	if !errors.As(err, &herr) {
+		t.Fatalf("error %v, want a HeaderError", err)
+	}
+	if herr.Assay != "b" || herr.Other != "merge" {
+		t.Errorf("header error %+v", *herr)
+	}
+
+	// a contig length conflict is only an error with StrictHeader
+	long_contig := strings.Replace(test_vcf("S2", records), "length=1000", "length=2000", 1)
+	if _, _, err := run_merge(t, context.Background(), Options{Threshold: 0.9},
+		"a", test_vcf("S1", records), "b", long_contig); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	_, _, err = run_merge(t, context.Background(), Options{Threshold: 0.9, StrictHeader: true},
+		"a", test_vcf("S1", records), "b", long_contig)
+	if !errors.As(err, &herr) {
+		t.Fatalf("error %v, want a HeaderError", err)
+	}
+	if herr.Assay != "b" || herr.Other != "a" {
+		t.Errorf("header error %+v", *herr)
+	}
+}
+
+func TestRunDsNumberOne(t *testing.T) {
+	// DS as Minimac4 declares it, the same as Number=A for biallelic sites
+	ds1 := func(samples string, records ...string) string {
+		return strings.Replace(test_vcf(samples, records...), "##FORMAT=<ID=GP",
+			"##FORMAT=<ID=DS,Number=1,Type=Float,Description=\"Dosage\">\n##FORMAT=<ID=GP", 1)
+	}
+	w, metrics, err := run_merge(t, context.Background(), Options{Threshold: 0.9, StrictHeader: true},
+		"a", ds1("S1", "1 100 rs1 A G . PASS . GT:DS:GP 0/1:1:0,1,0"),
+		"b", ds1("S2", "1 100 rs1 A G . PASS . GT:DS:GP 1/1:2:0,0,1"))
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if len(metrics.HeaderConflicts) != 0 {
+		t.Errorf("header conflicts %v, want none", metrics.HeaderConflicts)
+	}
+	if !has_line(w.header, "##FORMAT=<ID=DS,Number=A,") {
+		t.Errorf("merge DS definition not written: %v", w.header)
+	}
+	want := "1 100 rs1 A G . PASS . GT:DS:GP:AT 0/1:1.000:0,1,0:a 1/1:2.000:0,0,1:b"
+	if len(w.records) != 1 || strings.Join(w.records[0], " ") != want {
+		t.Errorf("records %v, want %s", w.records, want)
+	}
+}
+
+// Does a header line start with prefix
+func has_line(header []string, prefix string) bool {
+	for _, line := range header {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRunPalindrome(t *testing.T) {
+	// A/T in a against T/A in b is a swap or a strand flip
+	tests := []struct {
+		name       string
+		palindrome string
+		af         string
+		want       string
+		action     string
+	}{
+		{"dropped", "", "0.1", "0/0:0.000:1,0,0:a ./.:.:.:.", report.ActionDrop},
+		{"swap by af", PalindromeAF, "0.9", "0/0:0.000:1,0,0:a 1/1:2.000:0,0,1:b", report.ActionSwap},
+		{"flip by af", PalindromeAF, "0.1", "0/0:0.000:1,0,0:a 0/0:0.000:1,0,0:b", report.ActionFlip},
+		{"maf too high", PalindromeAF, "0.55", "0/0:0.000:1,0,0:a ./.:.:.:.", report.ActionDrop},
+	}
+	for _, tt := range tests {
+		var rejections strings.Builder
+		w, _, err := run_merge(t, context.Background(),
+			Options{Threshold: 0.9, Palindrome: tt.palindrome, Rejections: &rejections},
+			"a", test_vcf("S1", "1 100 rs1 A T . PASS AF=0.1 GT:GP 0/0:1,0,0"),
+			"b", test_vcf("S2", "1 100 rs1 T A . PASS AF="+tt.af+" GT:GP 0/0:1,0,0"))
+		if err != nil {
+			t.Fatalf("%s: Run: %v", tt.name, err)
+		}
+		if len(w.records) != 1 {
+			t.Fatalf("%s: %d records written, want 1", tt.name, len(w.records))
+		}
+		if got := strings.Join(w.records[0][9:], " "); got != tt.want {
+			t.Errorf("%s: samples %s, want %s", tt.name, got, tt.want)
+		}
+		rows := strings.Split(strings.TrimSpace(rejections.String()), "\n")
+		if len(rows) != 2 || strings.Split(rows[1], "\t")[6] != tt.action {
+			t.Errorf("%s: rejections %q, want one %s", tt.name, rows, tt.action)
+		}
+	}
+}
+
+func TestNewManifest(t *testing.T) {
+	records := "1 100 rs1 A G . PASS . GT:GP 0/1:0,1,0"
+	sources := test_sources(t, "a", test_vcf("S1", records), "b", test_vcf("S2", records))
+	manifest := assay.Manifest{"a": &assay.Assay{Name: "a", Abbrev: "A"}}
+	if _, err := New(sources, Options{Threshold: 0.9, Manifest: manifest}, &memWriter{}); err == nil ||
+		!strings.Contains(err.Error(), "no assay b") {
+		t.Errorf("assay b not in the manifest: error %v", err)
+	}
+	manifest["b"] = &assay.Assay{Name: "b"}
+	w, _, err := run_merge(t, context.Background(), Options{Threshold: 0.9, Manifest: manifest},
+		"a", test_vcf("S1", records), "b", test_vcf("S2", records))
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	// b has no abbreviation, AT is its name
+	if got := strings.Join(w.records[0][9:], " "); got != "0/1:1.000:0,1,0:A 0/1:1.000:0,1,0:b" {
+		t.Errorf("samples %s", got)
+	}
+}
+
+func TestRunParseError(t *testing.T) {
+	_, _, err := run_merge(t, context.Background(), Options{Threshold: 0.9},
+		"a", test_vcf("S1",
+			"1 100 rs1 A G . PASS . GT:GP 0/1:0,1,0",
+			"1 x rs2 C T . PASS . GT:GP 0/1:0,1,0"),
+		"b", test_vcf("S2",
+			"1 100 rs1 A G . PASS . GT:GP 0/1:0,1,0"))
+	var perr *vcfio.ParseError
+	if !errors.As(err, &perr) {
+		t.Fatalf("error %v, want a *vcfio.ParseError", err)
+	}
+	// 4 meta lines, the column header, then the records
+	if perr.File != "a.vcf" || perr.Line != 7 {
+		t.Errorf("parse error at %s:%d, want a.vcf:7", perr.File, perr.Line)
+	}
+}
+
+func TestRunCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w, metrics, err := run_merge(t, ctx, Options{Threshold: 0.9},
+		"a", test_vcf("S1", "1 100 rs1 A G . PASS . GT:GP 0/1:0,1,0"),
+		"b", test_vcf("S2", "1 100 rs1 A G . PASS . GT:GP 0/1:0,1,0"))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("error %v, want %v", err, context.Canceled)
+	}
+	if metrics != nil || len(w.records) != 0 {
+		t.Errorf("cancelled run wrote %d records", len(w.records))
+	}
+}
+
+func TestRunOnce(t *testing.T) {
+	w := &memWriter{}
+	m, err := New(test_sources(t,
+		"a", test_vcf("S1", "1 100 rs1 A G . PASS . GT:GP 0/1:0,1,0"),
+		"b", test_vcf("S2", "1 100 rs1 A G . PASS . GT:GP 0/1:0,1,0")), Options{Threshold: 0.9}, w)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if _, err := m.Run(context.Background()); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if _, err := m.Run(context.Background()); err == nil {
+		t.Errorf("second Run: no error")
+	}
+}
+
+func TestIdentityWindows(t *testing.T) {
+	contigs := []vcfheader.Contig{{ID: "1", Length: 249000000}, {ID: "2", Length: 243000000}, {ID: "MT"}}
+	order := variant.MakeContigOrder([]string{"1", "2", "MT"})
+	tests := []struct {
+		chrom   string
+		regions []region.Region
+		spread  bool
+		counts  map[string]int
+	}{
+		{WholeGenome, nil, true, map[string]int{"1": 253, "2": 247, "MT": 1}},
+		{"2", nil, true, map[string]int{"2": ibs.Windows}},
+		{"MT", nil, true, map[string]int{"MT": 1}},
+		{WholeGenome, nil, false, map[string]int{"1": 1, "2": 1, "MT": 1}},
+		{WholeGenome, []region.Region{{Chrom: "2", Beg: 0, End: 1000}}, true, map[string]int{"2": 1}},
+	}
+	for _, tt := range tests {
+		m := &Merger{opts: Options{Chrom: tt.chrom}, contigs: contigs, contigOrder: order}
+		windows := m.identity_windows(tt.regions, tt.spread)
+		counts := make(map[string]int)
+		for i, win := range windows {
+			counts[win.Chrom]++
+			if tt.spread && win.Chrom != "MT" && len(tt.regions) == 0 && win.End-win.Beg > ibs.WindowSize {
+				t.Errorf("chrom %s: window %v longer than %d", tt.chrom, win, ibs.WindowSize)
+			}
+			if i > 0 && win.Chrom == windows[i-1].Chrom && win.Beg < windows[i-1].End {
+				t.Errorf("chrom %s: window %v overlaps %v", tt.chrom, win, windows[i-1])
+			}
+		}
+		if len(counts) != len(tt.counts) {
+			t.Errorf("chrom %s: windows on %v, want %v", tt.chrom, counts, tt.counts)
+		}
+		for chrom, n := range tt.counts {
+			if counts[chrom] != n {
+				t.Errorf("chrom %s: %d windows on %s, want %d", tt.chrom, counts[chrom], chrom, n)
+			}
+		}
+	}
+}
+
+func TestRunBcfContigs(t *testing.T) {
+	tests := []struct {
+		name    string
+		contig  string
+		chrom   string
+		contigs []string
+		want    string
+	}{
+		{"leading zero", "##contig=<ID=01,length=1000>\n", "01", nil, ""},
+		{"contig order", "", "1", []string{"1", "2"}, ""},
+		{"no contigs", "", "1", nil, "BCF output needs ##contig lines"},
+	}
+	for _, tt := range tests {
+		inputs := make([]string, 0, 4)
+		for _, at := range []string{"a", "b"} {
+			vcf := test_vcf("S_"+at, tt.chrom+" 100 rs1 A G . PASS . GT:GP 0/1:0,1,0")
+			inputs = append(inputs, at, strings.Replace(vcf, "##contig=<ID=1,length=1000>\n", tt.contig, 1))
+		}
+		path := filepath.Join(t.TempDir(), "out.bcf")
+		w, err := vcfio.Create(path)
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		m, err := New(test_sources(t, inputs...), Options{Threshold: 0.9, Contigs: tt.contigs}, w)
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		_, err = m.Run(context.Background())
+		w.Close()
+		if tt.want != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("%s: error %v, want %s", tt.name, err, tt.want)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Run: %v", tt.name, err)
+			continue
+		}
+		r, _, err := vcfio.NewReader(path)
+		if err != nil {
+			t.Fatalf("%s: NewReader: %v", tt.name, err)
+		}
+		rec, err := r.Read()
+		r.Close()
+		if err != nil || rec[0] != "1" || !has_line(r.MetaLines(), "##contig=<ID=1") || has_line(r.MetaLines(), "##contig=<ID=01") {
+			t.Errorf("%s: record %v (%v), contigs %v", tt.name, rec, err, vcfheader.GetContigs(r.MetaLines()))
+		}
+	}
+}
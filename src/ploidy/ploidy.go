@@ -0,0 +1,182 @@
+// Sample ploidy on the sex chromosomes and mitochondria, from sample sexes
+// and the pseudo-autosomal regions (PAR) of chromosome X
+package ploidy
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"region"
+	"strings"
+)
+
+// Sample sexes
+const (
+	Male    = "M"
+	Female  = "F"
+	Unknown = "U"
+)
+
+// Chromosome zones with their own ploidy rules
+const (
+	ZoneAutosome = "autosome"
+	ZoneXPar     = "X_PAR"
+	ZoneX        = "X"
+	ZoneY        = "Y"
+	ZoneMT       = "MT"
+)
+
+// X chromosome PAR1 and PAR2 for the supported builds (0-based, half-open)
+var parByBuild = map[string][]region.Region{
+	"grch37": {{Chrom: "X", Beg: 60000, End: 2699520}, {Chrom: "X", Beg: 154931043, End: 155260560}},
+	"grch38": {{Chrom: "X", Beg: 10000, End: 2781479}, {Chrom: "X", Beg: 155701382, End: 156030895}},
+}
+
+// Is there a built-in PAR for a genome build name
+func HasBuild(build string) bool {
+	_, ok := parByBuild[strings.ToLower(build)]
+	return ok
+}
+
+//-----------------------------------------------
+// Model: sexes of the samples and the PAR of X
+//-----------------------------------------------
+type Model struct {
+	sex map[string]string
+	par []region.Region
+}
+
+//------------------------------------------------------------------------------
+// A model from a sex file and a PAR specification: a build name (grch37,
+// grch38) or comma separated X regions (chr:start-end)
+//------------------------------------------------------------------------------
+func NewModel(sex_path string, par_spec string) (*Model, error) {
+	m := &Model{sex: make(map[string]string)}
+	if par, ok := parByBuild[strings.ToLower(par_spec)]; ok {
+		m.par = par
+	} else {
+		for _, spec := range strings.Split(par_spec, ",") {
+			reg, err := region.Parse(spec)
+			if err != nil {
+				return nil, fmt.Errorf("PAR: %v", err)
+			}
+			m.par = append(m.par, reg)
+		}
+	}
+	if sex_path == "" {
+		return m, nil
+	}
+	f, err := os.Open(sex_path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	lnum := 0
+	for scanner.Scan() {
+		lnum++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("%s:%d: expected sample and sex", sex_path, lnum)
+		}
+		sex, ok := parseSex(fields[1])
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: unknown sex %q for %s", sex_path, lnum, fields[1], fields[0])
+		}
+		m.sex[fields[0]] = sex
+	}
+	return m, scanner.Err()
+}
+
+// M/F, male/female, or PLINK codes 1 (male) and 2 (female), 0 or NA unknown
+func parseSex(code string) (string, bool) {
+	switch strings.ToLower(code) {
+	case "m", "male", "1":
+		return Male, true
+	case "f", "female", "2":
+		return Female, true
+	case "u", "unknown", "0", "na", "-9":
+		return Unknown, true
+	}
+	return "", false
+}
+
+func (m *Model) Sex(sample string) string {
+	if sex, ok := m.sex[sample]; ok {
+		return sex
+	}
+	return Unknown
+}
+
+// chromosome name without a chr prefix, PLINK numeric names mapped
+func chromName(chrom string) string {
+	name := strings.TrimPrefix(strings.TrimPrefix(chrom, "chr"), "Chr")
+	switch strings.ToUpper(name) {
+	case "X", "23":
+		return "X"
+	case "Y", "24":
+		return "Y"
+	case "M", "MT", "26":
+		return "MT"
+	}
+	return name
+}
+
+//------------------------------------------------------------------------------
+// The zone of a position (1-based)
+//------------------------------------------------------------------------------
+func (m *Model) Zone(chrom string, posn int64) string {
+	switch chromName(chrom) {
+	case "X":
+		for _, par := range m.par {
+			if chromName(par.Chrom) == "X" && posn-1 >= par.Beg && posn-1 < par.End {
+				return ZoneXPar
+			}
+		}
+		return ZoneX
+	case "Y":
+		return ZoneY
+	case "MT":
+		return ZoneMT
+	}
+	return ZoneAutosome
+}
+
+//------------------------------------------------------------------------------
+// Ploidy of a sample in a zone: males are haploid on X outside the PAR and on
+// Y, females have no Y, MT is haploid; samples of unknown sex are taken as
+// diploid on X and haploid on Y
+//------------------------------------------------------------------------------
+func (m *Model) Ploidy(zone string, sample string) int {
+	switch zone {
+	case ZoneX:
+		if m.Sex(sample) == Male {
+			return 1
+		}
+	case ZoneY:
+		if m.Sex(sample) == Female {
+			return 0
+		}
+		return 1
+	case ZoneMT:
+		return 1
+	}
+	return 2
+}
+
+//------------------------------------------------------------------------------
+// Ploidy of each sample in a zone, nil for the autosomes (all diploid)
+//------------------------------------------------------------------------------
+func (m *Model) Ploidies(zone string, samples []string) []int {
+	if zone == ZoneAutosome || zone == ZoneXPar {
+		return nil
+	}
+	ploidies := make([]int, len(samples))
+	for i, sample := range samples {
+		ploidies[i] = m.Ploidy(zone, sample)
+	}
+	return ploidies
+}
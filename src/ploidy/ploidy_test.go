@@ -0,0 +1,105 @@
+package ploidy
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// A model of the PAR given and a sex file of the lines given
+func test_model(t *testing.T, par_spec string, lines ...string) *Model {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "sex.txt")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0666); err != nil {
+		t.Fatalf("write sex file: %v", err)
+	}
+	m, err := NewModel(path, par_spec)
+	if err != nil {
+		t.Fatalf("NewModel: %v", err)
+	}
+	return m
+}
+
+func TestZone(t *testing.T) {
+	tests := []struct {
+		par   string
+		chrom string
+		posn  int64
+		want  string
+	}{
+		{"grch37", "X", 60000, ZoneX},
+		{"grch37", "X", 60001, ZoneXPar},
+		{"grch37", "chrX", 2699520, ZoneXPar},
+		{"grch37", "X", 2699521, ZoneX},
+		{"grch37", "23", 155000000, ZoneXPar},
+		{"grch37", "X", 155260561, ZoneX},
+		{"GRCh38", "X", 2699521, ZoneXPar},
+		{"grch38", "X", 2781480, ZoneX},
+		{"X:100-200", "X", 99, ZoneX},
+		{"X:100-200", "X", 100, ZoneXPar},
+		{"X:100-200", "chrX", 200, ZoneXPar},
+		{"X:100-200", "X", 201, ZoneX},
+		{"grch37", "Y", 10, ZoneY},
+		{"grch37", "chrY", 2699520, ZoneY},
+		{"grch37", "MT", 1, ZoneMT},
+		{"grch37", "chrM", 1, ZoneMT},
+		{"grch37", "26", 1, ZoneMT},
+		{"grch37", "1", 60001, ZoneAutosome},
+		{"grch37", "chr22", 1, ZoneAutosome},
+	}
+	for _, tt := range tests {
+		m, err := NewModel("", tt.par)
+		if err != nil {
+			t.Fatalf("NewModel(%s): %v", tt.par, err)
+		}
+		if got := m.Zone(tt.chrom, tt.posn); got != tt.want {
+			t.Errorf("PAR %s: Zone(%s, %d) = %s, want %s", tt.par, tt.chrom, tt.posn, got, tt.want)
+		}
+	}
+	if _, err := NewModel("", "X:200-100"); err == nil {
+		t.Errorf("PAR X:200-100: no error")
+	}
+}
+
+func TestPloidy(t *testing.T) {
+	m := test_model(t, "grch37", "# sample sex", "S1 M", "S2 F", "S3 1", "S4 2", "S5 NA")
+	tests := []struct {
+		zone   string
+		sample string
+		want   int
+	}{
+		{ZoneAutosome, "S1", 2},
+		{ZoneXPar, "S1", 2},
+		{ZoneX, "S1", 1},
+		{ZoneX, "S3", 1},
+		{ZoneX, "S2", 2},
+		{ZoneX, "S5", 2},
+		{ZoneX, "S9", 2},
+		{ZoneY, "S1", 1},
+		{ZoneY, "S4", 0},
+		{ZoneY, "S5", 1},
+		{ZoneMT, "S2", 1},
+	}
+	for _, tt := range tests {
+		if got := m.Ploidy(tt.zone, tt.sample); got != tt.want {
+			t.Errorf("Ploidy(%s, %s) = %d, want %d", tt.zone, tt.sample, got, tt.want)
+		}
+	}
+	if got := m.Ploidies(ZoneXPar, []string{"S1", "S2"}); got != nil {
+		t.Errorf("Ploidies on the PAR = %v, want nil (diploid)", got)
+	}
+	if got := m.Ploidies(ZoneX, []string{"S1", "S2", "S9"}); len(got) != 3 || got[0] != 1 || got[1] != 2 || got[2] != 2 {
+		t.Errorf("Ploidies on X = %v, want [1 2 2]", got)
+	}
+}
+
+func TestSexFileErrors(t *testing.T) {
+	for _, lines := range [][]string{{"S1 M", "S2 X"}, {"S1 M", "S2"}} {
+		path := filepath.Join(t.TempDir(), "sex.txt")
+		os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0666)
+		if _, err := NewModel(path, "grch37"); err == nil || !strings.Contains(err.Error(), "sex.txt:2:") {
+			t.Errorf("sex file %q: error %v, want one at line 2", lines, err)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+// Genomic regions to restrict a merge to, from the command line or a BED file
+package region
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"variant"
+)
+
+//-----------------------------------------------
+// Region: 0-based half-open interval [Beg, End) on a chromosome,
+// End is variant.MaxPosn for "to the end of the chromosome"
+//-----------------------------------------------
+type Region struct {
+	Chrom string
+	Beg   int64
+	End   int64
+}
+
+func (r Region) String() string {
+	if r.End >= variant.MaxPosn {
+		return fmt.Sprintf("%s:%d-", r.Chrom, r.Beg+1)
+	}
+	return fmt.Sprintf("%s:%d-%d", r.Chrom, r.Beg+1, r.End)
+}
+
+//------------------------------------------------------------------------------
+// Parse chr, chr:start or chr:start-end (1-based, inclusive, commas allowed)
+//------------------------------------------------------------------------------
+func Parse(spec string) (Region, error) {
+	reg := Region{Chrom: spec, Beg: 0, End: variant.MaxPosn}
+	colon := strings.LastIndex(spec, ":")
+	if colon < 0 {
+		return reg, nil
+	}
+	reg.Chrom = spec[:colon]
+	rng := strings.ReplaceAll(spec[colon+1:], ",", "")
+	start, end, has_end := strings.Cut(rng, "-")
+	var err error
+	if reg.Beg, err = strconv.ParseInt(start, 10, 64); err != nil || reg.Beg < 1 {
+		return reg, fmt.Errorf("bad region start in %q", spec)
+	}
+	reg.Beg--
+	if has_end && end != "" {
+		if reg.End, err = strconv.ParseInt(end, 10, 64); err != nil || reg.End <= reg.Beg {
+			return reg, fmt.Errorf("bad region end in %q", spec)
+		}
+	}
+	if reg.Chrom == "" {
+		return reg, fmt.Errorf("no chromosome in region %q", spec)
+	}
+	return reg, nil
+}
+
+//------------------------------------------------------------------------------
+// Read a BED file (0-based start, exclusive end), header, track and browser
+// lines are skipped
+//------------------------------------------------------------------------------
+func ReadBed(path string) ([]Region, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	regions := make([]Region, 0)
+	scanner := bufio.NewScanner(f)
+	lnum := 0
+	for scanner.Scan() {
+		lnum++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") || strings.HasPrefix(text, "track") ||
+			strings.HasPrefix(text, "browser") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("%s:%d: expected chrom, start and end", path, lnum)
+		}
+		beg, berr := strconv.ParseInt(fields[1], 10, 64)
+		end, eerr := strconv.ParseInt(fields[2], 10, 64)
+		if berr != nil || eerr != nil || beg < 0 || end <= beg {
+			return nil, fmt.Errorf("%s:%d: bad interval %s-%s", path, lnum, fields[1], fields[2])
+		}
+		regions = append(regions, Region{Chrom: fields[0], Beg: beg, End: end})
+	}
+	return regions, scanner.Err()
+}
+
+//------------------------------------------------------------------------------
+// Sort regions into contig order and merge those that overlap or abut,
+// the result is the order records are read in
+//------------------------------------------------------------------------------
+func Normalise(regions []Region, order variant.ContigOrder) []Region {
+	sorted := append([]Region(nil), regions...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if c := order.CompareChroms(variant.KeyChrom(sorted[i].Chrom), variant.KeyChrom(sorted[j].Chrom)); c != 0 {
+			return c < 0
+		}
+		return sorted[i].Beg < sorted[j].Beg
+	})
+	merged := make([]Region, 0, len(sorted))
+	for _, reg := range sorted {
+		if n := len(merged); n > 0 && variant.SameChrom(merged[n-1].Chrom, reg.Chrom) && reg.Beg <= merged[n-1].End {
+			if reg.End > merged[n-1].End {
+				merged[n-1].End = reg.End
+			}
+		} else {
+			merged = append(merged, reg)
+		}
+	}
+	return merged
+}
+
+// Does [beg, end) overlap the region
+func (r Region) Overlaps(chrom string, beg int64, end int64) bool {
+	if end <= beg {
+		end = beg + 1
+	}
+	return variant.SameChrom(r.Chrom, chrom) && beg < r.End && end > r.Beg
+}
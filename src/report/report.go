@@ -0,0 +1,104 @@
+// Structured reports written alongside a merge run
+package report
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+//-----------------------------------------------
+// Rejection: a decision taken about one assay record
+//-----------------------------------------------
+type Rejection struct {
+	Chrom     string
+	Posn      int64
+	Varid     string
+	Assaytype string
+	Ref       string
+	Alt       string
+	Action    string
+	Reason    string
+}
+
+const (
+	ActionSwap     = "swap"
+	ActionFlip     = "flip"
+	ActionFlipSwap = "flip_swap"
+	ActionDrop     = "drop"
+	ActionFilter   = "filter"
+	ActionRemap    = "remap"
+)
+
+var rejectionColumns = []string{"CHROM", "POS", "ID", "ASSAY", "REF", "ALT", "ACTION", "REASON"}
+
+//-----------------------------------------------
+// RejectionWriter: writes Rejections as TSV rows
+//-----------------------------------------------
+type RejectionWriter struct {
+	w     *bufio.Writer
+	Count map[string]int
+}
+
+func NewRejectionWriter(w io.Writer) *RejectionWriter {
+	rw := &RejectionWriter{w: bufio.NewWriter(w), Count: make(map[string]int)}
+	rw.w.WriteString("#" + strings.Join(rejectionColumns, "\t") + "\n")
+	return rw
+}
+
+func (rw *RejectionWriter) Write(rej Rejection) {
+	rw.Count[rej.Action]++
+	rw.w.WriteString(strings.Join([]string{rej.Chrom, strconv.FormatInt(rej.Posn, 10), rej.Varid,
+		rej.Assaytype, rej.Ref, rej.Alt, rej.Action, rej.Reason}, "\t") + "\n")
+}
+
+func (rw *RejectionWriter) Flush() error {
+	return rw.w.Flush()
+}
+
+//-----------------------------------------------
+// Concordance: the calls of a sample compared between two assays
+//-----------------------------------------------
+type Concordance struct {
+	Sample     string
+	Assay1     string
+	Assay2     string
+	Compared   int
+	Concordant int
+	Discordant int
+	Missing1   int
+	Missing2   int
+}
+
+var concordanceColumns = []string{"SAMPLE", "ASSAY1", "ASSAY2", "COMPARED", "CONCORDANT", "DISCORDANT",
+	"MISSING1", "MISSING2", "CONCORDANCE"}
+
+//-----------------------------------------------
+// ConcordanceWriter: writes Concordance rows as TSV, CONCORDANCE is the
+// fraction of the calls made by both assays that agree ("." if none)
+//-----------------------------------------------
+type ConcordanceWriter struct {
+	w *bufio.Writer
+}
+
+func NewConcordanceWriter(w io.Writer) *ConcordanceWriter {
+	cw := &ConcordanceWriter{w: bufio.NewWriter(w)}
+	cw.w.WriteString("#" + strings.Join(concordanceColumns, "\t") + "\n")
+	return cw
+}
+
+func (cw *ConcordanceWriter) Write(conc Concordance) {
+	rate := "."
+	if called := conc.Concordant + conc.Discordant; called > 0 {
+		rate = fmt.Sprintf("%.4f", float64(conc.Concordant)/float64(called))
+	}
+	cw.w.WriteString(strings.Join([]string{conc.Sample, conc.Assay1, conc.Assay2, strconv.Itoa(conc.Compared),
+		strconv.Itoa(conc.Concordant), strconv.Itoa(conc.Discordant), strconv.Itoa(conc.Missing1),
+		strconv.Itoa(conc.Missing2), rate}, "\t") + "\n")
+}
+
+func (cw *ConcordanceWriter) Flush() error {
+	return cw.w.Flush()
+}
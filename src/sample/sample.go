@@ -1,8 +1,11 @@
 package sample
 
 import (
+	"bufio"
 	"fmt"
+	"os"
 	"sort"
+	"strings"
 )
 
 //---------------------------------------------------
@@ -48,6 +51,8 @@ func GetCombinedSampleMap(samplesByAssayType map[string]map[string]int) map[stri
 }
 
 //---------------------------------------------------
+// Samples in the order first seen, taking the assays in assayTypeList order
+// and each assay's samples in header order
 //---------------------------------------------------
 func GetCombinedSampleMapByAssaytypes(samplesByAssayType map[string]map[string]int, assayTypeList []string) map[string]int {
 	sample_index := make(map[string]int, len(samplesByAssayType))
@@ -55,7 +60,7 @@ func GetCombinedSampleMapByAssaytypes(samplesByAssayType map[string]map[string]i
 	idx := 0
 	for _, atype := range assayTypeList {
 		if posns, ok := samplesByAssayType[atype]; ok {
-			for samp, _ := range posns {
+			for _, samp := range by_posn(posns) {
 				if _, ok := sample_index[samp]; !ok {
 					sample_index[samp] = idx
 					idx++
@@ -69,3 +74,135 @@ func GetCombinedSampleMapByAssaytypes(samplesByAssayType map[string]map[string]i
 
 	return sample_index
 }
+
+//---------------------------------------------------
+// A sample rename file: old and new ID per line (whitespace separated),
+// lines starting # are comments
+//---------------------------------------------------
+func ReadRenameFile(path string) (map[string]string, error) {
+	rename := make(map[string]string)
+	err := read_lines(path, func(fields []string) error {
+		if len(fields) != 2 {
+			return fmt.Errorf("expected old and new sample ID, got %d fields", len(fields))
+		}
+		if _, ok := rename[fields[0]]; ok {
+			return fmt.Errorf("sample %s renamed twice", fields[0])
+		}
+		rename[fields[0]] = fields[1]
+		return nil
+	})
+	return rename, err
+}
+
+//---------------------------------------------------
+// A sample list file: a sample ID per line (the first field)
+//---------------------------------------------------
+func ReadListFile(path string) (map[string]bool, error) {
+	list := make(map[string]bool)
+	err := read_lines(path, func(fields []string) error {
+		list[fields[0]] = true
+		return nil
+	})
+	return list, err
+}
+
+func read_lines(path string, parse func([]string) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if err := parse(fields); err != nil {
+			return fmt.Errorf("%s:%d: %v", path, lineno, err)
+		}
+	}
+	return scanner.Err()
+}
+
+//---------------------------------------------------
+// Samples of an assay after renaming (rename may be nil) and filtering on
+// the new IDs: kept if in keep (when keep is not nil) and not in remove.
+// Returns the new IDs and their columns in the assay, in assay order
+//---------------------------------------------------
+func SelectSamples(samples []string, rename map[string]string, keep map[string]bool,
+	remove map[string]bool) ([]string, []int, error) {
+	names := make([]string, 0, len(samples))
+	cols := make([]int, 0, len(samples))
+	seen := make(map[string]string, len(samples))
+	for i, name := range samples {
+		if newname, ok := rename[name]; ok {
+			name = newname
+		}
+		if (keep != nil && !keep[name]) || remove[name] {
+			continue
+		}
+		if old, ok := seen[name]; ok {
+			return nil, nil, fmt.Errorf("samples %s and %s both named %s", old, samples[i], name)
+		}
+		seen[name] = samples[i]
+		names = append(names, name)
+		cols = append(cols, i)
+	}
+	return names, cols, nil
+}
+
+// Sample names in header order
+func by_posn(posns map[string]int) []string {
+	names := make([]string, 0, len(posns))
+	for name := range posns {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return posns[names[i]] < posns[names[j]] })
+	return names
+}
+
+//---------------------------------------------------
+// Samples in the order given (names not in any assay are skipped), then,
+// if add_unlisted, the samples not listed in alphabetical order
+//---------------------------------------------------
+func GetCombinedSampleMapByOrder(samplesByAssayType map[string]map[string]int, order []string, add_unlisted bool) map[string]int {
+	combined := GetCombinedSampleMap(samplesByAssayType)
+	sample_index := make(map[string]int, len(combined))
+
+	idx := 0
+	for _, samp := range order {
+		if _, ok := combined[samp]; ok {
+			sample_index[samp] = idx
+			idx++
+		}
+	}
+	if add_unlisted {
+		for _, samp := range by_posn(combined) {
+			if _, ok := sample_index[samp]; !ok {
+				sample_index[samp] = idx
+				idx++
+			}
+		}
+	}
+	return sample_index
+}
+
+//---------------------------------------------------
+// A sample order file: a sample ID per line (the first field)
+//---------------------------------------------------
+func ReadOrderFile(path string) ([]string, error) {
+	order := make([]string, 0)
+	seen := make(map[string]bool)
+	err := read_lines(path, func(fields []string) error {
+		if seen[fields[0]] {
+			return fmt.Errorf("sample %s listed twice", fields[0])
+		}
+		seen[fields[0]] = true
+		order = append(order, fields[0])
+		return nil
+	})
+	return order, err
+}
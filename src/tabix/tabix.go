@@ -0,0 +1,497 @@
+// Tabix (.tbi) and coordinate-sorted (.csi) indexes for BGZF compressed VCF
+package tabix
+
+import (
+	"bgzf"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// tbi binning: 16kb windows, 5 levels, positions below 2^29
+const (
+	TbiMinShift = 14
+	TbiDepth    = 5
+	TbiMaxPosn  = int64(1) << 29
+)
+
+// tabix configuration for VCF
+const (
+	formatVcf = 2
+	colSeq    = 1
+	colBeg    = 2
+	colEnd    = 0
+	metaChar  = '#'
+)
+
+//-----------------------------------------------
+// Chunk: a range of virtual file offsets
+//-----------------------------------------------
+type Chunk struct {
+	Beg uint64
+	End uint64
+}
+
+//-----------------------------------------------
+// refIndex: bins and linear index for one contig
+//-----------------------------------------------
+type refIndex struct {
+	bins     map[uint32][]Chunk
+	binList  []uint32
+	linear   []uint64
+	loffsets map[uint32]uint64
+	lastBeg  int64
+	count    int
+}
+
+//-----------------------------------------------
+// Builder: accumulates an index while records are written
+//-----------------------------------------------
+type Builder struct {
+	MinShift uint
+	Depth    uint
+	NoConf   bool
+	names    []string
+	refs     map[string]*refIndex
+	current  string
+}
+
+//------------------------------------------------------------------------------
+// A builder with the binning needed for positions up to max_len: the
+// tbi scheme when it suffices, otherwise a deeper csi scheme
+//------------------------------------------------------------------------------
+func NewBuilder(max_len int64) *Builder {
+	depth := uint(TbiDepth)
+	for max_len > int64(1)<<(TbiMinShift+3*depth) {
+		depth++
+	}
+	return &Builder{MinShift: TbiMinShift, Depth: depth, refs: make(map[string]*refIndex)}
+}
+
+//------------------------------------------------------------------------------
+// Fix the reference list (for BCF, the header contig dictionary), the csi
+// is then written without tabix configuration
+//------------------------------------------------------------------------------
+func (b *Builder) SetRefs(names []string) {
+	b.NoConf = true
+	for _, name := range names {
+		if _, ok := b.refs[name]; !ok {
+			b.refs[name] = &refIndex{bins: make(map[uint32][]Chunk)}
+			b.names = append(b.names, name)
+		}
+	}
+}
+
+// An index that needs more than the tbi levels can only be written as csi
+func (b *Builder) NeedsCSI() bool {
+	return b.Depth > TbiDepth
+}
+
+func (b *Builder) maxPosn() int64 {
+	return int64(1) << (b.MinShift + 3*b.Depth)
+}
+
+//------------------------------------------------------------------------------
+// Add a record covering [beg, end) (0-based) stored at virtual offsets
+// [vbeg, vend), records must arrive sorted within a contig and each
+// contig in one run
+//------------------------------------------------------------------------------
+func (b *Builder) Add(chrom string, beg int64, end int64, vbeg uint64, vend uint64) error {
+	if end <= beg {
+		end = beg + 1
+	}
+	if end > b.maxPosn() {
+		return fmt.Errorf("%s:%d beyond indexable length %d, declare the ##contig length", chrom, end, b.maxPosn())
+	}
+	ref, ok := b.refs[chrom]
+	if !ok {
+		ref = &refIndex{bins: make(map[uint32][]Chunk)}
+		b.refs[chrom] = ref
+		b.names = append(b.names, chrom)
+	} else if chrom != b.current && ref.count > 0 {
+		return fmt.Errorf("%s: contig records are not contiguous", chrom)
+	} else if beg < ref.lastBeg {
+		return fmt.Errorf("%s:%d: records are not sorted", chrom, beg+1)
+	}
+	b.current = chrom
+	ref.lastBeg = beg
+	ref.count++
+
+	bin := Reg2bin(beg, end, b.MinShift, b.Depth)
+	chunks, ok := ref.bins[bin]
+	if !ok {
+		ref.binList = append(ref.binList, bin)
+	}
+	if n := len(chunks); n > 0 && chunks[n-1].End>>16 == vbeg>>16 {
+		chunks[n-1].End = vend
+	} else {
+		chunks = append(chunks, Chunk{Beg: vbeg, End: vend})
+	}
+	ref.bins[bin] = chunks
+
+	for w := beg >> b.MinShift; w <= (end-1)>>b.MinShift; w++ {
+		for int64(len(ref.linear)) <= w {
+			ref.linear = append(ref.linear, ^uint64(0))
+		}
+		if ref.linear[w] == ^uint64(0) {
+			ref.linear[w] = vbeg
+		}
+	}
+	return nil
+}
+
+//------------------------------------------------------------------------------
+// Binning scheme (SAM/tabix specification): smallest bin containing [beg, end)
+//------------------------------------------------------------------------------
+func Reg2bin(beg int64, end int64, min_shift uint, depth uint) uint32 {
+	end--
+	s := min_shift
+	t := ((1 << (3 * depth)) - 1) / 7
+	for l := depth; l > 0; l-- {
+		if beg>>s == end>>s {
+			return uint32(t + int(beg>>s))
+		}
+		s += 3
+		t -= 1 << (3 * (l - 1))
+	}
+	return 0
+}
+
+//------------------------------------------------------------------------------
+// All bins that may hold records overlapping [beg, end)
+//------------------------------------------------------------------------------
+func Reg2bins(beg int64, end int64, min_shift uint, depth uint) []uint32 {
+	bins := make([]uint32, 0)
+	end--
+	s := min_shift + 3*depth
+	t := 0
+	for l := uint(0); l <= depth; l++ {
+		for b := t + int(beg>>s); b <= t+int(end>>s); b++ {
+			bins = append(bins, uint32(b))
+		}
+		t += 1 << (3 * l)
+		s -= 3
+	}
+	return bins
+}
+
+// first linear index window of a bin
+func binFirstWindow(bin uint32, depth uint) int64 {
+	t := 0
+	for l := uint(0); l <= depth; l++ {
+		next := t + 1<<(3*l)
+		if int(bin) < next {
+			return int64(int(bin)-t) << (3 * (depth - l))
+		}
+		t = next
+	}
+	return 0
+}
+
+// fill unset windows so each holds the lowest offset of any record
+// overlapping it or a later window
+func (ref *refIndex) finishLinear() {
+	first := ^uint64(0)
+	for _, off := range ref.linear {
+		if off != ^uint64(0) {
+			first = off
+			break
+		}
+	}
+	for i := range ref.linear {
+		if ref.linear[i] == ^uint64(0) {
+			if i == 0 {
+				ref.linear[i] = first
+			} else {
+				ref.linear[i] = ref.linear[i-1]
+			}
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
+// tabix header fields for VCF, shared by tbi and csi (as csi aux data)
+//------------------------------------------------------------------------------
+func (b *Builder) confBytes() []byte {
+	names := make([]byte, 0)
+	for _, name := range b.names {
+		names = append(append(names, name...), 0)
+	}
+	conf := make([]byte, 0, 28+len(names))
+	for _, v := range []int32{formatVcf, colSeq, colBeg, colEnd, metaChar, 0, int32(len(names))} {
+		conf = binary.LittleEndian.AppendUint32(conf, uint32(v))
+	}
+	return append(conf, names...)
+}
+
+//------------------------------------------------------------------------------
+// Write a .tbi index, BGZF compressed
+//------------------------------------------------------------------------------
+func (b *Builder) WriteTBI(w io.Writer) error {
+	if b.NeedsCSI() {
+		return fmt.Errorf("positions beyond %d need a csi index", TbiMaxPosn)
+	}
+	out := []byte("TBI\x01")
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(b.names)))
+	out = append(out, b.confBytes()...)
+	for _, name := range b.names {
+		ref := b.refs[name]
+		ref.finishLinear()
+		out = binary.LittleEndian.AppendUint32(out, uint32(len(ref.binList)))
+		for _, bin := range ref.binList {
+			out = appendBin(out, bin, ref.bins[bin])
+		}
+		out = binary.LittleEndian.AppendUint32(out, uint32(len(ref.linear)))
+		for _, off := range ref.linear {
+			out = binary.LittleEndian.AppendUint64(out, off)
+		}
+	}
+	return writeCompressed(w, out)
+}
+
+//------------------------------------------------------------------------------
+// Write a .csi index, BGZF compressed
+//------------------------------------------------------------------------------
+func (b *Builder) WriteCSI(w io.Writer) error {
+	out := []byte("CSI\x01")
+	conf := []byte{}
+	if !b.NoConf {
+		conf = b.confBytes()
+	}
+	for _, v := range []uint32{uint32(b.MinShift), uint32(b.Depth), uint32(len(conf))} {
+		out = binary.LittleEndian.AppendUint32(out, v)
+	}
+	out = append(out, conf...)
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(b.names)))
+	for _, name := range b.names {
+		ref := b.refs[name]
+		ref.finishLinear()
+		out = binary.LittleEndian.AppendUint32(out, uint32(len(ref.binList)))
+		for _, bin := range ref.binList {
+			loffset := uint64(0)
+			if win := binFirstWindow(bin, b.Depth); win < int64(len(ref.linear)) {
+				loffset = ref.linear[win]
+			}
+			out = binary.LittleEndian.AppendUint32(out, bin)
+			out = binary.LittleEndian.AppendUint64(out, loffset)
+			out = appendChunks(out, ref.bins[bin])
+		}
+	}
+	return writeCompressed(w, out)
+}
+
+func appendBin(out []byte, bin uint32, chunks []Chunk) []byte {
+	out = binary.LittleEndian.AppendUint32(out, bin)
+	return appendChunks(out, chunks)
+}
+
+func appendChunks(out []byte, chunks []Chunk) []byte {
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(chunks)))
+	for _, chunk := range chunks {
+		out = binary.LittleEndian.AppendUint64(out, chunk.Beg)
+		out = binary.LittleEndian.AppendUint64(out, chunk.End)
+	}
+	return out
+}
+
+func writeCompressed(w io.Writer, data []byte) error {
+	bw := bgzf.NewWriter(w)
+	if _, err := bw.Write(data); err != nil {
+		return err
+	}
+	return bw.Close()
+}
+
+//-----------------------------------------------
+// Index: a .tbi or .csi index read back for region queries
+//-----------------------------------------------
+type Index struct {
+	MinShift uint
+	Depth    uint
+	Names    []string
+	csi      bool
+	refs     []*refIndex
+}
+
+//-----------------------------------------------
+// decoder: little-endian fields from the decompressed index
+//-----------------------------------------------
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (dc *decoder) take(n int) []byte {
+	if dc.err != nil || len(dc.data) < n {
+		dc.err = fmt.Errorf("truncated index")
+		if n > 8 {
+			return nil
+		}
+		return make([]byte, n)
+	}
+	b := dc.data[:n]
+	dc.data = dc.data[n:]
+	return b
+}
+
+func (dc *decoder) uint32() uint32 {
+	return binary.LittleEndian.Uint32(dc.take(4))
+}
+
+func (dc *decoder) uint64() uint64 {
+	return binary.LittleEndian.Uint64(dc.take(8))
+}
+
+func (dc *decoder) chunks() []Chunk {
+	n := int(dc.uint32())
+	chunks := make([]Chunk, 0)
+	for i := 0; i < n && dc.err == nil; i++ {
+		chunks = append(chunks, Chunk{Beg: dc.uint64(), End: dc.uint64()})
+	}
+	return chunks
+}
+
+// contig names from the tabix configuration fields
+func (dc *decoder) names() []string {
+	dc.take(24)
+	names := make([]string, 0)
+	for _, name := range strings.Split(string(dc.take(int(dc.uint32()))), "\x00") {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+//------------------------------------------------------------------------------
+// Read a .tbi or .csi index (BGZF compressed), a csi written without tabix
+// configuration (as for BCF) has no Names, they come from the BCF header
+//------------------------------------------------------------------------------
+func ReadIndex(r io.Reader) (*Index, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(gr)
+	if err != nil {
+		return nil, err
+	}
+	dc := &decoder{data: data}
+	idx := &Index{MinShift: TbiMinShift, Depth: TbiDepth}
+	n_ref := 0
+	switch magic := string(dc.take(4)); magic {
+	case "TBI\x01":
+		n_ref = int(dc.uint32())
+		idx.Names = dc.names()
+	case "CSI\x01":
+		idx.csi = true
+		idx.MinShift = uint(dc.uint32())
+		idx.Depth = uint(dc.uint32())
+		if aux := dc.take(int(dc.uint32())); len(aux) >= 28 {
+			idx.Names = (&decoder{data: aux}).names()
+		}
+		n_ref = int(dc.uint32())
+	default:
+		return nil, fmt.Errorf("not a tbi or csi index")
+	}
+	for i := 0; i < n_ref && dc.err == nil; i++ {
+		ref := &refIndex{bins: make(map[uint32][]Chunk), loffsets: make(map[uint32]uint64)}
+		n_bin := int(dc.uint32())
+		for j := 0; j < n_bin && dc.err == nil; j++ {
+			bin := dc.uint32()
+			if idx.csi {
+				ref.loffsets[bin] = dc.uint64()
+			}
+			ref.bins[bin] = dc.chunks()
+		}
+		if !idx.csi {
+			n_intv := int(dc.uint32())
+			for j := 0; j < n_intv && dc.err == nil; j++ {
+				ref.linear = append(ref.linear, dc.uint64())
+			}
+		}
+		idx.refs = append(idx.refs, ref)
+	}
+	if dc.err != nil {
+		return nil, dc.err
+	}
+	return idx, nil
+}
+
+//------------------------------------------------------------------------------
+// Lowest offset any record overlapping beg can start at: from the linear
+// index (tbi) or the loffset of the nearest enclosing bin present (csi)
+//------------------------------------------------------------------------------
+func (idx *Index) minOffset(ref *refIndex, beg int64) uint64 {
+	if !idx.csi {
+		if len(ref.linear) == 0 {
+			return 0
+		}
+		w := beg >> idx.MinShift
+		if w >= int64(len(ref.linear)) {
+			w = int64(len(ref.linear)) - 1
+		}
+		return ref.linear[w]
+	}
+	for bin := Reg2bin(beg, beg+1, idx.MinShift, idx.Depth); ; bin = (bin - 1) >> 3 {
+		if loffset, ok := ref.loffsets[bin]; ok {
+			return loffset
+		}
+		if bin == 0 {
+			return 0
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
+// Chunks that may hold records on chrom overlapping [beg, end) (0-based),
+// in file order with overlapping chunks merged
+//------------------------------------------------------------------------------
+func (idx *Index) Query(chrom string, beg int64, end int64) []Chunk {
+	chunks := make([]Chunk, 0)
+	tid := -1
+	for i, name := range idx.Names {
+		if name == chrom {
+			tid = i
+			break
+		}
+	}
+	if tid < 0 || tid >= len(idx.refs) {
+		return chunks
+	}
+	if max_posn := int64(1) << (idx.MinShift + 3*idx.Depth); end > max_posn {
+		end = max_posn
+	}
+	if beg < 0 {
+		beg = 0
+	}
+	if beg >= end {
+		return chunks
+	}
+	ref := idx.refs[tid]
+	min_off := idx.minOffset(ref, beg)
+	for _, bin := range Reg2bins(beg, end, idx.MinShift, idx.Depth) {
+		for _, chunk := range ref.bins[bin] {
+			if chunk.End > min_off {
+				chunks = append(chunks, chunk)
+			}
+		}
+	}
+	sort.Slice(chunks, func(i, j int) bool {
+		return chunks[i].Beg < chunks[j].Beg
+	})
+	merged := make([]Chunk, 0, len(chunks))
+	for _, chunk := range chunks {
+		if n := len(merged); n > 0 && chunk.Beg <= merged[n-1].End {
+			if chunk.End > merged[n-1].End {
+				merged[n-1].End = chunk.End
+			}
+		} else {
+			merged = append(merged, chunk)
+		}
+	}
+	return merged
+}
@@ -0,0 +1,272 @@
+package tabix
+
+import (
+	"bgzf"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+//-----------------------------------------------
+// testFile: a BGZF compressed VCF body and its index builder
+//-----------------------------------------------
+type testFile struct {
+	data    []byte
+	builder *Builder
+	records map[string][]int64
+}
+
+// Records of 1 bp at each position by contig, contigs in the order given,
+// the header written first as tabix skips it
+func write_test_file(t *testing.T, max_len int64, contigs []string, posns map[string][]int64) *testFile {
+	t.Helper()
+	var buf bytes.Buffer
+	bw := bgzf.NewWriter(&buf)
+	bw.WriteString("##fileformat=VCFv4.2\n#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\n")
+	b := NewBuilder(max_len)
+	for _, chrom := range contigs {
+		for _, posn := range posns[chrom] {
+			vbeg := bw.Tell()
+			fmt.Fprintf(bw, "%s\t%d\trs%d\tA\tG\t.\tPASS\t.\n", chrom, posn, posn)
+			if err := b.Add(chrom, posn-1, posn, vbeg, bw.Tell()); err != nil {
+				t.Fatalf("Add %s:%d: %v", chrom, posn, err)
+			}
+		}
+	}
+	if err := bw.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return &testFile{data: buf.Bytes(), builder: b, records: posns}
+}
+
+// 3000 records 1 kb apart on 1 (over several BGZF blocks and 16 kb
+// windows), a few on 2 and one on 3
+func test_posns() ([]string, map[string][]int64) {
+	posns := map[string][]int64{"2": {5, 16384, 16385, 1000000}, "3": {70000}}
+	for i := int64(0); i < 3000; i++ {
+		posns["1"] = append(posns["1"], 1000+1000*i)
+	}
+	return []string{"1", "2", "3"}, posns
+}
+
+// The decompressed index written by write
+func written_index(t *testing.T, write func(io.Writer) error) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := write(&buf); err != nil {
+		t.Fatalf("write index: %v", err)
+	}
+	gr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("index not gzip: %v", err)
+	}
+	data, err := io.ReadAll(gr)
+	if err != nil {
+		t.Fatalf("index not gzip: %v", err)
+	}
+	return data
+}
+
+func TestWriteIndex(t *testing.T) {
+	contigs, posns := test_posns()
+	tf := write_test_file(t, TbiMaxPosn, contigs, posns)
+	u32 := func(data []byte, at int) uint32 {
+		return binary.LittleEndian.Uint32(data[at:])
+	}
+
+	tbi := written_index(t, tf.builder.WriteTBI)
+	if string(tbi[:4]) != "TBI\x01" || u32(tbi, 4) != 3 {
+		t.Fatalf("tbi header % x", tbi[:8])
+	}
+	// format, seq, beg, end, meta, skip, then the contig names
+	conf := []uint32{formatVcf, colSeq, colBeg, colEnd, metaChar, 0, 6}
+	for i, want := range conf {
+		if got := u32(tbi, 8+4*i); got != want {
+			t.Errorf("tbi configuration field %d = %d, want %d", i, got, want)
+		}
+	}
+	if names := string(tbi[36:42]); names != "1\x002\x003\x00" {
+		t.Errorf("tbi contig names %q", names)
+	}
+
+	csi := written_index(t, tf.builder.WriteCSI)
+	if string(csi[:4]) != "CSI\x01" || u32(csi, 4) != TbiMinShift || u32(csi, 8) != TbiDepth {
+		t.Fatalf("csi header % x", csi[:12])
+	}
+	if aux := u32(csi, 12); aux != 28+6 || u32(csi, 16+int(aux)) != 3 {
+		t.Errorf("csi: %d bytes of configuration, %d contigs", aux, u32(csi, 16+int(aux)))
+	}
+}
+
+func TestLongContig(t *testing.T) {
+	long := int64(1) << 31
+	posns := map[string][]int64{"1": {100, TbiMaxPosn + 10, long - 10}}
+	tf := write_test_file(t, long, []string{"1"}, posns)
+	if !tf.builder.NeedsCSI() {
+		t.Fatalf("contig of %d: no csi needed", long)
+	}
+	if err := tf.builder.WriteTBI(&bytes.Buffer{}); err == nil {
+		t.Errorf("WriteTBI beyond %d: no error", TbiMaxPosn)
+	}
+	if csi := written_index(t, tf.builder.WriteCSI); binary.LittleEndian.Uint32(csi[8:]) != TbiDepth+1 {
+		t.Errorf("csi depth %d, want %d", binary.LittleEndian.Uint32(csi[8:]), TbiDepth+1)
+	}
+	b := NewBuilder(TbiMaxPosn)
+	if err := b.Add("1", TbiMaxPosn, TbiMaxPosn+1, 0, 1); err == nil {
+		t.Errorf("Add beyond the tbi length: no error")
+	}
+}
+
+func TestAddOrder(t *testing.T) {
+	b := NewBuilder(TbiMaxPosn)
+	if err := b.Add("1", 100, 101, 0, 10); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if err := b.Add("1", 50, 51, 10, 20); err == nil {
+		t.Errorf("unsorted record: no error")
+	}
+	b.Add("2", 10, 11, 20, 30)
+	if err := b.Add("1", 200, 201, 30, 40); err == nil {
+		t.Errorf("contig in two runs: no error")
+	}
+}
+
+func TestReg2bin(t *testing.T) {
+	tests := []struct {
+		beg, end int64
+		want     uint32
+	}{
+		{0, 1, 4681},
+		{0, 1 << 14, 4681},
+		{0, 1<<14 + 1, 585},
+		{1 << 14, 1<<14 + 1, 4682},
+		{0, TbiMaxPosn, 0},
+	}
+	for _, tt := range tests {
+		if got := Reg2bin(tt.beg, tt.end, TbiMinShift, TbiDepth); got != tt.want {
+			t.Errorf("Reg2bin(%d, %d) = %d, want %d", tt.beg, tt.end, got, tt.want)
+		}
+		found := false
+		for _, bin := range Reg2bins(tt.beg, tt.end, TbiMinShift, TbiDepth) {
+			found = found || bin == tt.want
+		}
+		if !found {
+			t.Errorf("Reg2bins(%d, %d) does not hold bin %d", tt.beg, tt.end, tt.want)
+		}
+	}
+}
+
+func (tf *testFile) index(t *testing.T, csi bool) *Index {
+	t.Helper()
+	var buf bytes.Buffer
+	write := tf.builder.WriteTBI
+	if csi {
+		write = tf.builder.WriteCSI
+	}
+	if err := write(&buf); err != nil {
+		t.Fatalf("write index: %v", err)
+	}
+	idx, err := ReadIndex(&buf)
+	if err != nil {
+		t.Fatalf("ReadIndex: %v", err)
+	}
+	return idx
+}
+
+// Positions of the records on chrom overlapping [beg, end) read from the
+// chunks of a query, as tabix does: every record in a chunk, then filtered
+func (tf *testFile) query(t *testing.T, idx *Index, chrom string, beg int64, end int64) []int64 {
+	t.Helper()
+	br := bgzf.NewReader(bytes.NewReader(tf.data))
+	posns := make([]int64, 0)
+	for _, chunk := range idx.Query(chrom, beg, end) {
+		if err := br.Seek(chunk.Beg); err != nil {
+			t.Fatalf("Seek %x: %v", chunk.Beg, err)
+		}
+		for br.Tell() < chunk.End {
+			line, err := br.ReadString('\n')
+			if err != nil {
+				t.Fatalf("ReadString: %v", err)
+			}
+			var rec_chrom string
+			var posn int64
+			fmt.Sscanf(line, "%s\t%d", &rec_chrom, &posn)
+			if rec_chrom == chrom && posn-1 < end && posn > beg {
+				posns = append(posns, posn)
+			}
+		}
+	}
+	return posns
+}
+
+// Positions of the records on chrom overlapping [beg, end), from the list
+func (tf *testFile) expected(chrom string, beg int64, end int64) []int64 {
+	posns := make([]int64, 0)
+	for _, posn := range tf.records[chrom] {
+		if posn-1 < end && posn > beg {
+			posns = append(posns, posn)
+		}
+	}
+	return posns
+}
+
+func TestQuery(t *testing.T) {
+	contigs, posns := test_posns()
+	tf := write_test_file(t, TbiMaxPosn, contigs, posns)
+	tests := []struct {
+		chrom    string
+		beg, end int64
+	}{
+		{"1", 0, 1000},
+		{"1", 999, 1000},
+		{"1", 1000, 1999},
+		{"1", 15000, 40000},
+		{"1", 1500000, 1500001},
+		{"1", 1234567, 2345678},
+		{"1", 2999000, 3000000},
+		{"1", 0, TbiMaxPosn},
+		{"2", 0, 10},
+		{"2", 16383, 16385},
+		{"2", 16384, 999999},
+		{"2", 999999, 1000000},
+		{"3", 0, TbiMaxPosn},
+		{"3", 0, 69999},
+	}
+	for _, csi := range []bool{false, true} {
+		idx := tf.index(t, csi)
+		if strings.Join(idx.Names, ",") != "1,2,3" {
+			t.Errorf("csi %t: contigs %v", csi, idx.Names)
+		}
+		for _, tt := range tests {
+			got, want := tf.query(t, idx, tt.chrom, tt.beg, tt.end), tf.expected(tt.chrom, tt.beg, tt.end)
+			if fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("csi %t: %s:%d-%d: %d records, want %d", csi, tt.chrom, tt.beg, tt.end, len(got), len(want))
+			}
+		}
+		for _, chrom := range []string{"4", "chr1", ""} {
+			if chunks := idx.Query(chrom, 0, TbiMaxPosn); len(chunks) != 0 {
+				t.Errorf("csi %t: contig %q not indexed: %d chunks", csi, chrom, len(chunks))
+			}
+		}
+		if chunks := idx.Query("1", 5000, 5000); len(chunks) != 0 {
+			t.Errorf("csi %t: empty range: %d chunks", csi, len(chunks))
+		}
+	}
+}
+
+func TestQueryLongContig(t *testing.T) {
+	long := int64(1) << 31
+	posns := map[string][]int64{"1": {100, TbiMaxPosn + 10, long - 10}}
+	tf := write_test_file(t, long, []string{"1"}, posns)
+	idx := tf.index(t, true)
+	for _, r := range [][2]int64{{0, 200}, {TbiMaxPosn, TbiMaxPosn + 100}, {long - 100, long}, {0, long}} {
+		got, want := tf.query(t, idx, "1", r[0], r[1]), tf.expected("1", r[0], r[1])
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("1:%d-%d: %v, want %v", r[0], r[1], got, want)
+		}
+	}
+}
@@ -3,14 +3,11 @@ package variant
 
 import (
 	"fmt"
-	"log"
+	"sort"
 	"strconv"
 	"strings"
 )
 
-var sglDigitChrom map[string]int
-var geno_strings = []string{"0/0", "0/1", "1/1"}
-
 const firstGenoIdx = 9
 const chrIdx = 0
 const posnIdx = 1
@@ -22,24 +19,51 @@ const filtIdx = 6
 const infoIdx = 7
 const fmtIdx = 8
 
-func init() {
-	sglDigitChrom = make(map[string]int)
-	sglDigitChrom["atest"] = 1
-	sglDigitChrom["atest2"] = 1
-	sglDigitChrom["atest3"] = 1
-	sglDigitChrom["atest4"] = 1
-	sglDigitChrom["atest5"] = 1
-	sglDigitChrom["affy"] = 1
-	sglDigitChrom["illumina"] = 1
-	sglDigitChrom["broad"] = 1
+// MaxPosn is the position given to the key of an exhausted input
+const MaxPosn int64 = 9999999999
+
+//------------------------------------------------------------------------------
+// VarKey: the composite merge key for a record, CHROM, POS, REF and ALT
+//------------------------------------------------------------------------------
+type VarKey struct {
+	Chrom string
+	Posn  int64
+	Ref   string
+	Alt   string
 }
 
-func check(e error) {
-	//  fmt.Println("check")
-	if e != nil {
-		fmt.Println("err != nil")
-		log.Fatal(e)
+// MaxKey sorts after every real record key
+var MaxKey = VarKey{Posn: MaxPosn}
+
+//------------------------------------------------------------------------------
+// ContigOrder: rank of each chromosome name, taken from header ##contig lines
+// or a user-supplied list, names not ranked sort after all ranked names
+//------------------------------------------------------------------------------
+type ContigOrder map[string]int
+
+func MakeContigOrder(contigs []string) ContigOrder {
+	order := make(ContigOrder, len(contigs))
+	for _, contig := range contigs {
+		name := normaliseChromName(contig)
+		if _, ok := order[name]; !ok {
+			order[name] = len(order)
+		}
 	}
+	return order
+}
+
+func (co ContigOrder) Has(chrom string) bool {
+	_, ok := co[normaliseChromName(chrom)]
+	return ok
+}
+
+func GetFormat(prfx []string) string {
+	return prfx[fmtIdx]
+}
+
+func SetFormat(prfx []string, format string) []string {
+	prfx[fmtIdx] = format
+	return prfx
 }
 
 func AppendToFmt(prfx []string, add_str string) []string {
@@ -54,6 +78,72 @@ func NormaliseChromosome(prfx []string) []string {
 	return prfx
 }
 
+//------------------------------------------------------------------------------
+// Key ordering: chromosome, position, REF then ALT, exhausted keys last
+//------------------------------------------------------------------------------
+func (k VarKey) IsMax() bool {
+	return k.Posn >= MaxPosn
+}
+
+func CompareKeys(a VarKey, b VarKey) int {
+	return ContigOrder(nil).Compare(a, b)
+}
+
+func (co ContigOrder) CompareChroms(a string, b string) int {
+	ra, aok := co[a]
+	rb, bok := co[b]
+	switch {
+	case aok && bok:
+		return ra - rb
+	case aok:
+		return -1
+	case bok:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func (co ContigOrder) Compare(a VarKey, b VarKey) int {
+	if a.IsMax() || b.IsMax() {
+		switch {
+		case a.IsMax() && b.IsMax():
+			return 0
+		case a.IsMax():
+			return 1
+		}
+		return -1
+	}
+	if c := co.CompareChroms(a.Chrom, b.Chrom); c != 0 {
+		return c
+	}
+	if a.Posn != b.Posn {
+		if a.Posn < b.Posn {
+			return -1
+		}
+		return 1
+	}
+	if c := strings.Compare(a.Ref, b.Ref); c != 0 {
+		return c
+	}
+	return strings.Compare(a.Alt, b.Alt)
+}
+
+func SameChrom(a string, b string) bool {
+	return normaliseChromName(a) == normaliseChromName(b)
+}
+
+// Chromosome name as held in a VarKey
+func KeyChrom(chrom string) string {
+	return normaliseChromName(chrom)
+}
+
+func normaliseChromName(chrom string) string {
+	if len(chrom) > 1 && chrom[0] == '0' {
+		return chrom[1:]
+	}
+	return chrom
+}
+
 //------------------------------------------------------------------------------
 // get geno based on threshold
 //------------------------------------------------------------------------------
@@ -61,23 +151,85 @@ func Get_geno(geno string, threshold float64, probidx int) string {
 
 	mprob, max_prob_idx, genoarray := MaxProb(geno, probidx)
 	//fmt.Printf("GGENO %s,%f,%f,%d\n", geno, mprob, threshold, probidx)
-	if mprob < threshold {
+	// no GP (or all GP zero) is a missing call even at threshold 0
+	if max_prob_idx < 0 || mprob < threshold {
 		genoarray[0] = "./."
-	} else {
-		genoarray[0] = geno_strings[max_prob_idx]
+	} else if called := GenotypeString(max_prob_idx); !IsPhased(genoarray[0]) || !SameGenotype(called, genoarray[0]) {
+		// a phased GT agreeing with the call is kept, with its phase
+		genoarray[0] = called
 	}
 	return strings.Join(genoarray, ":")
 }
 
+//------------------------------------------------------------------------------
+// Get_geno for a sample of the given ploidy, nalleles counting REF: a haploid
+// call is the allele with the highest GP ("." below threshold), GP of diploid
+// length is first reduced to its homozygous entries and renormalised, and a
+// value without GP has its GT made haploid
+//------------------------------------------------------------------------------
+func Get_geno_ploidy(geno string, threshold float64, probidx int, ploidy int, nalleles int) string {
+	if ploidy != 1 {
+		return Get_geno(geno, threshold, probidx)
+	}
+	genoarray := strings.Split(geno, ":")
+	if probidx < 0 || probidx >= len(genoarray) || genoarray[probidx] == "." {
+		genoarray[0] = HaploidGenotype(genoarray[0])
+		return strings.Join(genoarray, ":")
+	}
+	probs := strings.Split(genoarray[probidx], ",")
+	if len(probs) == nalleles*(nalleles+1)/2 && nalleles > 1 {
+		hprobs := make([]float64, nalleles)
+		total := 0.0
+		for a := range hprobs {
+			hprobs[a], _ = strconv.ParseFloat(probs[GenotypeIndex(a, a)], 64)
+			total += hprobs[a]
+		}
+		probs = probs[:nalleles]
+		for a, prob := range hprobs {
+			if total > 0.0 {
+				prob /= total
+			}
+			probs[a] = strconv.FormatFloat(prob, 'f', 3, 64)
+		}
+		genoarray[probidx] = strings.Join(probs, ",")
+	}
+	genoarray[0] = "."
+	max_prob := 0.0
+	for a, prob := range probs {
+		if probf, err := strconv.ParseFloat(prob, 64); err == nil && probf > max_prob {
+			max_prob = probf
+			if probf >= threshold {
+				genoarray[0] = strconv.Itoa(a)
+			}
+		}
+	}
+	return strings.Join(genoarray, ":")
+}
+
+// A GT as a haploid call: homozygous alleles become one allele, heterozygous
+// or missing alleles a missing call
+func HaploidGenotype(gt string) string {
+	alleles := splitGenotype(gt)
+	if len(alleles) == 0 {
+		return "."
+	}
+	for _, allele := range alleles[1:] {
+		if allele != alleles[0] {
+			return "."
+		}
+	}
+	return alleles[0]
+}
+
 //------------------------------------------------------------------------------
 // maxprob test for a genotype
 //------------------------------------------------------------------------------
 func MaxProb(geno string, probidx int) (float64, int, []string) {
 	g := strings.Split(geno, ":")
-	//if len(g) < (probidx + 1) {
-	//	fmt.Printf("GENO IDX PROBLEM %d, %s\n", probidx, geno)
-	//	return 0.0, -9, g
-	//}
+	if probidx < 0 || len(g) < (probidx+1) {
+		// no GP (e.g. "." or trailing fields dropped)
+		return 0.0, -9, g
+	}
 	probs := strings.Split(g[probidx], ",")
 	max_prob := 0.0
 	max_prob_idx := -9
@@ -107,6 +259,21 @@ func GetPosn(recslice []string) int64 {
 	value, _ := strconv.ParseInt(recslice[posnIdx], 0, 64)
 	return value
 }
+
+func GetChrom(recslice []string) string {
+	return recslice[chrIdx]
+}
+
+func GetKey(recslice []string) VarKey {
+	return VarKey{Chrom: normaliseChromName(recslice[chrIdx]), Posn: GetPosn(recslice),
+		Ref: recslice[refIdx], Alt: recslice[altIdx]}
+}
+
+func SetVarid(recslice []string, varid string) []string {
+	recslice[varIdx] = varid
+	return recslice
+}
+
 func GetAlleles(recslice []string) (string, string) {
 	return recslice[refIdx], recslice[altIdx]
 }
@@ -115,19 +282,60 @@ func GetProbidx(recslice []string) int {
 	return getStrIdx(recslice[fmtIdx], "GP")
 }
 
-func GetRefPanelAf(recslice []string) float64 {
+// Reference panel frequency of the non-REF alleles, the per-ALT values of a
+// multi-allelic RefPanelAF summed, false when absent or not a number
+func GetRefPanelAf(recslice []string) (float64, bool) {
 	infomap := parseInfoStr(GetInfo(recslice))
-	if maf, ok := infomap["RefPanelAF"]; ok {
-		refpaf, _ := strconv.ParseFloat(maf, 64)
-		return refpaf
+	maf, ok := infomap["RefPanelAF"]
+	if !ok {
+		return 0.0, false
+	}
+	refpaf := 0.0
+	for _, v := range strings.Split(maf, ",") {
+		af, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return 0.0, false
+		}
+		refpaf += af
+	}
+	return refpaf, true
+}
+
+// ALT allele frequency of a biallelic record, INFO AF or else RefPanelAF
+func GetAltFreq(recslice []string) (float64, bool) {
+	if af, ok := GetInfoFloat(recslice, "AF"); ok {
+		return af, true
 	}
-	return 0.0
+	return GetRefPanelAf(recslice)
 }
 
 func GetInfo(recslice []string) string {
 	return recslice[infoIdx]
 }
 
+func GetInfoFloat(recslice []string, key string) (float64, bool) {
+	infomap := parseInfoStr(GetInfo(recslice))
+	if v, ok := infomap[key]; ok {
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f, true
+		}
+	}
+	return 0.0, false
+}
+
+// Imputation quality, INFO (IMPUTE/Sanger) or R2 (minimac)
+func GetInfoScore(recslice []string) (float64, bool) {
+	if score, ok := GetInfoFloat(recslice, "INFO"); ok {
+		return score, true
+	}
+	return GetInfoFloat(recslice, "R2")
+}
+
+func SetFilter(recslice []string, filter string) []string {
+	recslice[filtIdx] = filter
+	return recslice
+}
+
 func parseInfoStr(info_str string) map[string]string {
 	infomap := make(map[string]string)
 	infodata := strings.Split(info_str, ";")
@@ -149,3 +357,354 @@ func getStrIdx(str string, match_str string) int {
 	}
 	return -9
 }
+
+//------------------------------------------------------------------------------
+// Allele harmonisation: compare the alleles of a record with those of another
+// assay at the same site, and rewrite a record to swapped or flipped alleles
+//------------------------------------------------------------------------------
+const (
+	AllelesMatch     = "match"
+	AllelesSwap      = "swap"
+	AllelesFlip      = "flip"
+	AllelesFlipSwap  = "flip_swap"
+	AllelesAmbiguous = "ambiguous"
+	AllelesNone      = ""
+)
+
+var complementBase = map[byte]byte{'A': 'T', 'T': 'A', 'C': 'G', 'G': 'C'}
+
+func ComplementAllele(allele string) (string, bool) {
+	comp := make([]byte, len(allele))
+	for i := 0; i < len(allele); i++ {
+		c, ok := complementBase[allele[i]]
+		if !ok {
+			return allele, false
+		}
+		comp[i] = c
+	}
+	return string(comp), true
+}
+
+func IsPalindromic(ref string, alt string) bool {
+	comp, ok := ComplementAllele(ref)
+	return ok && comp == alt
+}
+
+// How ref/alt relate to ref2/alt2, only biallelic records are swapped or flipped
+// Palindromic alleles against their reverse (A/T and T/A) are either a swap
+// or a strand flip, which the alleles alone cannot tell, so are ambiguous
+func MatchAlleles(ref string, alt string, ref2 string, alt2 string) string {
+	if ref == ref2 && alt == alt2 {
+		return AllelesMatch
+	}
+	if strings.Contains(alt, ",") || strings.Contains(alt2, ",") {
+		return AllelesNone
+	}
+	if ref == alt2 && alt == ref2 && IsPalindromic(ref, alt) {
+		return AllelesAmbiguous
+	}
+	if ref == alt2 && alt == ref2 {
+		return AllelesSwap
+	}
+	cref, rok := ComplementAllele(ref)
+	calt, aok := ComplementAllele(alt)
+	if !rok || !aok {
+		return AllelesNone
+	}
+	if cref == ref2 && calt == alt2 {
+		return AllelesFlip
+	}
+	if cref == alt2 && calt == ref2 {
+		return AllelesFlipSwap
+	}
+	return AllelesNone
+}
+
+// REF alleles at a site are compatible if one is a prefix of the other
+func RefsCompatible(ref string, ref2 string) bool {
+	return strings.HasPrefix(ref, ref2) || strings.HasPrefix(ref2, ref)
+}
+
+func FlipStrand(recslice []string) []string {
+	recslice[refIdx], _ = ComplementAllele(recslice[refIdx])
+	recslice[altIdx], _ = ComplementAllele(recslice[altIdx])
+	return recslice
+}
+
+// Exchange REF and ALT of a biallelic record, recoding GT, reversing GP,
+// complementing DS and allele frequencies
+func SwapAlleles(recslice []string) []string {
+	recslice[refIdx], recslice[altIdx] = recslice[altIdx], recslice[refIdx]
+	infomap := parseInfoStr(recslice[infoIdx])
+	for _, af := range []string{"RefPanelAF", "AF"} {
+		if v, ok := infomap[af]; ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				recslice[infoIdx] = replaceInfoValue(recslice[infoIdx], af, strconv.FormatFloat(1.0-f, 'g', 6, 64))
+			}
+		}
+	}
+	gtidx := getStrIdx(recslice[fmtIdx], "GT")
+	dsidx := getStrIdx(recslice[fmtIdx], "DS")
+	gpidx := getStrIdx(recslice[fmtIdx], "GP")
+	for i := firstGenoIdx; i < len(recslice); i++ {
+		g := strings.Split(recslice[i], ":")
+		ploidy := 2
+		if gtidx >= 0 && gtidx < len(g) {
+			g[gtidx] = swapGenotype(g[gtidx])
+			ploidy = len(splitGenotype(g[gtidx]))
+		}
+		if gpidx >= 0 && gpidx < len(g) && g[gpidx] != "." {
+			probs := strings.Split(g[gpidx], ",")
+			for l, r := 0, len(probs)-1; l < r; l, r = l+1, r-1 {
+				probs[l], probs[r] = probs[r], probs[l]
+			}
+			g[gpidx] = strings.Join(probs, ",")
+		}
+		if dsidx >= 0 && dsidx < len(g) {
+			if ds, err := strconv.ParseFloat(g[dsidx], 64); err == nil {
+				g[dsidx] = strconv.FormatFloat(float64(ploidy)-ds, 'f', 3, 64)
+			}
+		}
+		recslice[i] = strings.Join(g, ":")
+	}
+	return recslice
+}
+
+func swapGenotype(gt string) string {
+	swapped := []byte(gt)
+	for i, c := range swapped {
+		switch c {
+		case '0':
+			swapped[i] = '1'
+		case '1':
+			swapped[i] = '0'
+		}
+	}
+	if strings.Contains(gt, "/") {
+		alleles := splitGenotype(string(swapped))
+		sort.Strings(alleles)
+		return strings.Join(alleles, "/")
+	}
+	return string(swapped)
+}
+
+func splitGenotype(gt string) []string {
+	return strings.FieldsFunc(gt, func(r rune) bool { return r == '/' || r == '|' })
+}
+
+//------------------------------------------------------------------------------
+// Phase-aware GT comparison: genotypes are the same if they carry the same
+// alleles, whatever their order or phasing (0|1, 1|0 and 0/1 are all the same)
+//------------------------------------------------------------------------------
+func SameGenotype(gt1 string, gt2 string) bool {
+	return gt1 == gt2 || UnphasedGenotype(gt1) == UnphasedGenotype(gt2)
+}
+
+// GT with the alleles in ascending order and unphased, e.g. 1|0 is 0/1
+func UnphasedGenotype(gt string) string {
+	alleles := splitGenotype(gt)
+	sort.Slice(alleles, func(i, j int) bool {
+		if len(alleles[i]) != len(alleles[j]) {
+			return len(alleles[i]) < len(alleles[j])
+		}
+		return alleles[i] < alleles[j]
+	})
+	return strings.Join(alleles, "/")
+}
+
+func IsPhased(gt string) bool {
+	return strings.Contains(gt, "|")
+}
+
+func replaceInfoValue(info_str string, key string, value string) string {
+	infodata := strings.Split(info_str, ";")
+	for i, elem := range infodata {
+		if strings.HasPrefix(elem, key+"=") {
+			infodata[i] = key + "=" + value
+		}
+	}
+	return strings.Join(infodata, ";")
+}
+
+//------------------------------------------------------------------------------
+// Multi-allelic genotypes: Number=G values (GP, PL) are in VCF genotype order,
+// for diploid calls the allele pair (j, k), j <= k, is at k(k+1)/2 + j
+//------------------------------------------------------------------------------
+func GenotypeIndex(j int, k int) int {
+	if j > k {
+		j, k = k, j
+	}
+	return k*(k+1)/2 + j
+}
+
+func GenotypeFromIndex(idx int) (int, int) {
+	k := 0
+	for (k+1)*(k+2)/2 <= idx {
+		k++
+	}
+	return idx - k*(k+1)/2, k
+}
+
+// Diploid GT for a genotype index: 0 is 0/0, 1 is 0/1, 2 is 1/1, 3 is 0/2 ...
+func GenotypeString(idx int) string {
+	j, k := GenotypeFromIndex(idx)
+	return strconv.Itoa(j) + "/" + strconv.Itoa(k)
+}
+
+// Number of alleles with n diploid genotypes, 0 if n is not such a count
+func AllelesForGenotypes(n int) int {
+	for a := 1; a*(a+1)/2 <= n; a++ {
+		if a*(a+1)/2 == n {
+			return a
+		}
+	}
+	return 0
+}
+
+// Number (A, R or G) of common keys, used when a header does not define them
+var standardNumbers = map[string]string{
+	"INFO/AF": "A", "INFO/AC": "A", "INFO/RefPanelAF": "A",
+	"FORMAT/GP": "G", "FORMAT/GL": "G", "FORMAT/PL": "G", "FORMAT/DS": "A", "FORMAT/AD": "R",
+}
+
+// Value for an allele or genotype a record did not have: probability or dosage 0
+var remapFill = map[string]string{"FORMAT/GP": "0", "FORMAT/DS": "0"}
+
+//------------------------------------------------------------------------------
+// Rewrite a record to a wider ALT list (containing all of its ALT alleles):
+// GT allele indices are remapped, as are INFO and FORMAT values with
+// Number A, R or G (numbers holds "INFO/ID" or "FORMAT/ID" to Number from the
+// header), values for the added alleles are missing
+//------------------------------------------------------------------------------
+func RemapAlleles(recslice []string, alts []string, numbers map[string]string) ([]string, error) {
+	old := strings.Split(recslice[altIdx], ",")
+	amap := make([]int, len(old)+1)
+	for i, alt := range old {
+		amap[i+1] = -1
+		for j, a := range alts {
+			if a == alt {
+				amap[i+1] = j + 1
+			}
+		}
+		if amap[i+1] < 0 {
+			return recslice, fmt.Errorf("ALT %s not in %s", alt, strings.Join(alts, ","))
+		}
+	}
+	number := func(key string) string {
+		if n, ok := numbers[key]; ok {
+			return n
+		}
+		return standardNumbers[key]
+	}
+	fill := func(key string) string {
+		if f, ok := remapFill[key]; ok {
+			return f
+		}
+		return "."
+	}
+	nalleles := len(alts) + 1
+	recslice[altIdx] = strings.Join(alts, ",")
+	infodata := strings.Split(recslice[infoIdx], ";")
+	for i, elem := range infodata {
+		if key, value, ok := strings.Cut(elem, "="); ok {
+			infodata[i] = key + "=" + remapValues(value, number("INFO/"+key), amap, nalleles, fill("INFO/"+key))
+		}
+	}
+	recslice[infoIdx] = strings.Join(infodata, ";")
+	fmtkeys := strings.Split(recslice[fmtIdx], ":")
+	for i := firstGenoIdx; i < len(recslice); i++ {
+		g := strings.Split(recslice[i], ":")
+		for k, key := range fmtkeys {
+			if k >= len(g) {
+				break
+			}
+			if key == "GT" {
+				g[k] = remapGenotype(g[k], amap)
+			} else {
+				g[k] = remapValues(g[k], number("FORMAT/"+key), amap, nalleles, fill("FORMAT/"+key))
+			}
+		}
+		recslice[i] = strings.Join(g, ":")
+	}
+	return recslice, nil
+}
+
+// allele indices of a GT through amap, separators and missing alleles kept,
+// unphased alleles are put back in ascending order
+func remapGenotype(gt string, amap []int) string {
+	if !strings.Contains(gt, "|") {
+		alleles := splitGenotype(gt)
+		idxs := make([]int, 0, len(alleles))
+		for _, allele := range alleles {
+			a, err := strconv.Atoi(allele)
+			if err != nil || a >= len(amap) {
+				idxs = nil
+				break
+			}
+			idxs = append(idxs, amap[a])
+		}
+		if len(idxs) > 0 {
+			sort.Ints(idxs)
+			for i, a := range idxs {
+				alleles[i] = strconv.Itoa(a)
+			}
+			return strings.Join(alleles, "/")
+		}
+	}
+	var remapped strings.Builder
+	start := 0
+	for i := 0; i <= len(gt); i++ {
+		if i < len(gt) && gt[i] != '/' && gt[i] != '|' {
+			continue
+		}
+		allele := gt[start:i]
+		if a, err := strconv.Atoi(allele); err == nil && a < len(amap) {
+			allele = strconv.Itoa(amap[a])
+		}
+		remapped.WriteString(allele)
+		if i < len(gt) {
+			remapped.WriteByte(gt[i])
+		}
+		start = i + 1
+	}
+	return remapped.String()
+}
+
+// per-allele or per-genotype values through amap, others unchanged
+func remapValues(value string, number string, amap []int, nalleles int, fill string) string {
+	if value == "." {
+		return value
+	}
+	vals := strings.Split(value, ",")
+	var out []string
+	filled := func(n int) []string {
+		f := make([]string, n)
+		for i := range f {
+			f[i] = fill
+		}
+		return f
+	}
+	switch {
+	case number == "A" && len(vals) == len(amap)-1:
+		out = filled(nalleles - 1)
+		for i, v := range vals {
+			out[amap[i+1]-1] = v
+		}
+	case (number == "R" || number == "G") && len(vals) == len(amap):
+		// R, or G for a haploid call
+		out = filled(nalleles)
+		for i, v := range vals {
+			out[amap[i]] = v
+		}
+	case number == "G" && len(vals) == len(amap)*(len(amap)+1)/2:
+		out = filled(nalleles * (nalleles + 1) / 2)
+		for k := 0; k < len(amap); k++ {
+			for j := 0; j <= k; j++ {
+				out[GenotypeIndex(amap[j], amap[k])] = vals[GenotypeIndex(j, k)]
+			}
+		}
+	default:
+		return value
+	}
+	return strings.Join(out, ",")
+}
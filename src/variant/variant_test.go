@@ -0,0 +1,123 @@
+package variant
+
+import (
+	"strings"
+	"testing"
+)
+
+// A record from space separated fields
+func test_record(line string) []string {
+	return strings.Split(line, " ")
+}
+
+func TestGenotypeIndex(t *testing.T) {
+	tests := []struct {
+		j, k int
+		idx  int
+		gt   string
+	}{
+		{0, 0, 0, "0/0"},
+		{0, 1, 1, "0/1"},
+		{1, 1, 2, "1/1"},
+		{0, 2, 3, "0/2"},
+		{1, 2, 4, "1/2"},
+		{2, 2, 5, "2/2"},
+		{0, 3, 6, "0/3"},
+		{3, 3, 9, "3/3"},
+	}
+	for _, tt := range tests {
+		if got := GenotypeIndex(tt.j, tt.k); got != tt.idx {
+			t.Errorf("GenotypeIndex(%d, %d) = %d, want %d", tt.j, tt.k, got, tt.idx)
+		}
+		if got := GenotypeIndex(tt.k, tt.j); got != tt.idx {
+			t.Errorf("GenotypeIndex(%d, %d) = %d, want %d", tt.k, tt.j, got, tt.idx)
+		}
+		if j, k := GenotypeFromIndex(tt.idx); j != tt.j || k != tt.k {
+			t.Errorf("GenotypeFromIndex(%d) = %d, %d, want %d, %d", tt.idx, j, k, tt.j, tt.k)
+		}
+		if got := GenotypeString(tt.idx); got != tt.gt {
+			t.Errorf("GenotypeString(%d) = %s, want %s", tt.idx, got, tt.gt)
+		}
+	}
+	for n, want := range map[int]int{1: 1, 3: 2, 6: 3, 10: 4, 4: 0, 0: 0} {
+		if got := AllelesForGenotypes(n); got != want {
+			t.Errorf("AllelesForGenotypes(%d) = %d, want %d", n, got, want)
+		}
+	}
+}
+
+func TestRemapAlleles(t *testing.T) {
+	numbers := map[string]string{"FORMAT/AD": "R"}
+	tests := []struct {
+		name string
+		rec  string
+		alts []string
+		want string
+	}{
+		{"ALT moved to second",
+			"1 100 rs1 A T . PASS AF=0.2;RefPanelAF=0.25;INFO=0.9 GT:DS:GP:AD 0/1:1:0.1,0.8,0.1:5,7 1|0:1:0,1,0:3,3",
+			[]string{"G", "T"},
+			"1 100 rs1 A G,T . PASS AF=.,0.2;RefPanelAF=.,0.25;INFO=0.9 GT:DS:GP:AD 0/2:0,1:0.1,0,0,0.8,0,0.1:5,.,7 2|0:0,1:0,0,0,1,0,0:3,.,3"},
+		{"same ALT list",
+			"1 100 rs1 A G . PASS . GT:GP 1/1:0,0,1",
+			[]string{"G"},
+			"1 100 rs1 A G . PASS . GT:GP 1/1:0,0,1"},
+		{"missing and haploid calls",
+			"X 100 rs1 A T . PASS . GT:GP ./.:. 1:0.1,0.9",
+			[]string{"C", "T"},
+			"X 100 rs1 A C,T . PASS . GT:GP ./.:. 2:0.1,0,0.9"},
+		{"multi-allelic widened",
+			"1 100 rs1 A C,T . PASS . GT:GP 1/2:0,0,0,0,1,0",
+			[]string{"T", "G", "C"},
+			"1 100 rs1 A T,G,C . PASS . GT:GP 1/3:0,0,0,0,0,0,0,1,0,0"},
+	}
+	for _, tt := range tests {
+		got, err := RemapAlleles(test_record(tt.rec), tt.alts, numbers)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if strings.Join(got, " ") != tt.want {
+			t.Errorf("%s:\n got %s\nwant %s", tt.name, strings.Join(got, " "), tt.want)
+		}
+	}
+	if _, err := RemapAlleles(test_record("1 100 rs1 A T . PASS . GT 0/1"), []string{"G"}, nil); err == nil {
+		t.Errorf("ALT not in the list: no error")
+	}
+}
+
+func TestSwapAlleles(t *testing.T) {
+	rec := test_record("1 100 rs1 A G . PASS AF=0.2;RefPanelAF=0.25 GT:DS:GP " +
+		"0/1:0.9:0.2,0.7,0.1 1|0:1.1:0,0.9,0.1 0/0:0.1:0.9,0.1,0 1:0.8:0.2,0.8 ./.:.:.")
+	want := "1 100 rs1 G A . PASS AF=0.8;RefPanelAF=0.75 GT:DS:GP " +
+		"0/1:1.100:0.1,0.7,0.2 0|1:0.900:0.1,0.9,0 1/1:1.900:0,0.1,0.9 0:0.200:0.8,0.2 ./.:.:."
+	if got := strings.Join(SwapAlleles(rec), " "); got != want {
+		t.Errorf("SwapAlleles:\n got %s\nwant %s", got, want)
+	}
+}
+
+func TestGetGenoPloidy(t *testing.T) {
+	tests := []struct {
+		name      string
+		geno      string
+		threshold float64
+		ploidy    int
+		nalleles  int
+		want      string
+	}{
+		{"diploid call", "0/0:1.000:0.05,0.9,0.05", 0.9, 2, 2, "0/1:1.000:0.05,0.9,0.05"},
+		{"diploid below threshold", "0/1:1.000:0.05,0.9,0.05", 0.95, 2, 2, "./.:1.000:0.05,0.9,0.05"},
+		{"diploid no GP", "0/1:1.000:.", 0.9, 2, 2, "./.:1.000:."},
+		{"haploid from diploid GP", "0/0:0.100:0.9,0.05,0.05", 0.9, 1, 2, "0:0.100:0.947,0.053"},
+		{"haploid below threshold", "1/1:1.000:0.4,0.2,0.4", 0.9, 1, 2, ".:1.000:0.500,0.500"},
+		{"haploid GP", "0/0:0.900:0.1,0.9", 0.9, 1, 2, "1:0.900:0.1,0.9"},
+		{"haploid multi-allelic", "0/0:.:0,0,0,0,0.02,0.98", 0.9, 1, 3, "2:.:0.000,0.000,1.000"},
+		{"haploid no GP, homozygous", "1/1:.:.", 0.9, 1, 2, "1:.:."},
+		{"haploid no GP, heterozygous", "0/1:.:.", 0.9, 1, 2, ".:.:."},
+	}
+	for _, tt := range tests {
+		if got := Get_geno_ploidy(tt.geno, tt.threshold, 2, tt.ploidy, tt.nalleles); got != tt.want {
+			t.Errorf("%s: Get_geno_ploidy(%s) = %s, want %s", tt.name, tt.geno, got, tt.want)
+		}
+	}
+}
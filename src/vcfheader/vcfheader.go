@@ -0,0 +1,272 @@
+// VCF header meta-line handling
+package vcfheader
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//-----------------------------------------------
+// Contig: data from a ##contig header line
+//-----------------------------------------------
+type Contig struct {
+	ID     string
+	Length int64
+}
+
+//------------------------------------------------------------------------------
+// Split a ##key=value meta line, structured values (<...>) are returned
+// as a map of their fields, quoted field values are unquoted
+//------------------------------------------------------------------------------
+func ParseMetaLine(line string) (string, string, map[string]string) {
+	line = strings.TrimPrefix(line, "##")
+	kv := strings.SplitN(line, "=", 2)
+	if len(kv) < 2 {
+		return kv[0], "", nil
+	}
+	key, value := kv[0], kv[1]
+	if !strings.HasPrefix(value, "<") || !strings.HasSuffix(value, ">") {
+		return key, value, nil
+	}
+	fields := make(map[string]string)
+	for _, elem := range splitStructured(value[1 : len(value)-1]) {
+		fkv := strings.SplitN(elem, "=", 2)
+		if len(fkv) == 2 {
+			fields[fkv[0]] = unquote(fkv[1])
+		} else {
+			fields[fkv[0]] = ""
+		}
+	}
+	return key, value, fields
+}
+
+//------------------------------------------------------------------------------
+// Contigs declared in a set of meta lines, in header order
+//------------------------------------------------------------------------------
+func GetContigs(meta_lines []string) []Contig {
+	contigs := make([]Contig, 0)
+	for _, line := range meta_lines {
+		if !strings.HasPrefix(line, "##contig=") {
+			continue
+		}
+		_, _, fields := ParseMetaLine(line)
+		if id, ok := fields["ID"]; ok {
+			length, _ := strconv.ParseInt(fields["length"], 10, 64)
+			contigs = append(contigs, Contig{ID: id, Length: length})
+		}
+	}
+	return contigs
+}
+
+//------------------------------------------------------------------------------
+// Combine contig lists: the order of the first list is kept and contigs
+// first seen in later lists are appended in the order they are met
+//------------------------------------------------------------------------------
+func MergeContigLists(contig_lists [][]Contig) []Contig {
+	merged := make([]Contig, 0)
+	seen := make(map[string]int)
+	for _, contigs := range contig_lists {
+		for _, contig := range contigs {
+			if i, ok := seen[contig.ID]; ok {
+				if merged[i].Length == 0 {
+					merged[i].Length = contig.Length
+				}
+				continue
+			}
+			seen[contig.ID] = len(merged)
+			merged = append(merged, contig)
+		}
+	}
+	return merged
+}
+
+//-----------------------------------------------
+// MetaLine: one parsed ## line and the input it came from
+//-----------------------------------------------
+type MetaLine struct {
+	Key    string
+	Value  string
+	Fields map[string]string
+	Source string
+	line   string
+}
+
+// ID of a structured line, "" if it has none
+func (ml *MetaLine) ID() string {
+	return ml.Fields["ID"]
+}
+
+func (ml *MetaLine) String() string {
+	return ml.line
+}
+
+//-----------------------------------------------
+// Conflict: an input line disagreeing with the definition already held,
+// the held definition is kept
+//-----------------------------------------------
+type Conflict struct {
+	Key    string
+	ID     string
+	Held   *MetaLine
+	Other  *MetaLine
+	Fields []string
+}
+
+func (c Conflict) String() string {
+	name := c.Key
+	if c.ID != "" {
+		name += "/" + c.ID
+	}
+	return fmt.Sprintf("%s: %s kept from %s, differs in %s from %s (%s)", name, c.Held, sourceName(c.Held.Source),
+		strings.Join(c.Fields, ","), sourceName(c.Other.Source), c.Other)
+}
+
+func sourceName(source string) string {
+	if source == "" {
+		return "merge"
+	}
+	return source
+}
+
+// keys taking a single value, differing values conflict
+var singleValueKeys = map[string]bool{"reference": true}
+
+// keys describing an input file rather than the merged output
+var perFileKeys = map[string]bool{"fileformat": true, "fileDate": true}
+
+//-----------------------------------------------
+// Header: the union of meta lines across inputs, in the order first seen,
+// structured lines are keyed by key and ID, other lines by their text
+//-----------------------------------------------
+type Header struct {
+	Fileformat string
+	lines      []*MetaLine
+	index      map[string]*MetaLine
+	Conflicts  []Conflict
+}
+
+func NewHeader(fileformat string) *Header {
+	return &Header{Fileformat: fileformat, index: make(map[string]*MetaLine)}
+}
+
+//------------------------------------------------------------------------------
+// Add a meta line from source ("" for lines describing the merge itself),
+// a line already held is skipped, a conflicting definition is recorded and
+// skipped, a contig length missing from the held line is filled in
+//------------------------------------------------------------------------------
+func (h *Header) Add(line string, source string) {
+	key, value, fields := ParseMetaLine(line)
+	if perFileKeys[key] {
+		return
+	}
+	ml := &MetaLine{Key: key, Value: value, Fields: fields, Source: source, line: line}
+	ident := key + "=" + value
+	if id, ok := fields["ID"]; ok {
+		ident = key + "/" + id
+	} else if singleValueKeys[key] {
+		ident = key
+	}
+	held, ok := h.index[ident]
+	if !ok {
+		h.index[ident] = ml
+		h.lines = append(h.lines, ml)
+		return
+	}
+	if fields == nil {
+		if held.Value != value {
+			h.Conflicts = append(h.Conflicts, Conflict{Key: key, Held: held, Other: ml, Fields: []string{"value"}})
+		}
+		return
+	}
+	if key == "contig" && held.Fields["length"] == "" && fields["length"] != "" {
+		held.Fields["length"] = fields["length"]
+		held.Value = held.Value[:len(held.Value)-1] + ",length=" + fields["length"] + ">"
+		held.line = "##" + key + "=" + held.Value
+	}
+	if differ := differentFields(held.Fields, fields); len(differ) > 0 {
+		h.Conflicts = append(h.Conflicts, Conflict{Key: key, ID: fields["ID"], Held: held, Other: ml, Fields: differ})
+	}
+}
+
+// fields set in both definitions with differing values, descriptions aside
+func differentFields(a map[string]string, b map[string]string) []string {
+	differ := make([]string, 0)
+	for name, value := range a {
+		if other, ok := b[name]; ok && other != value && name != "Description" {
+			differ = append(differ, name)
+		}
+	}
+	sort.Strings(differ)
+	return differ
+}
+
+//------------------------------------------------------------------------------
+// Get a structured definition by key and ID
+//------------------------------------------------------------------------------
+func (h *Header) Get(key string, id string) (*MetaLine, bool) {
+	ml, ok := h.index[key+"/"+id]
+	return ml, ok
+}
+
+//------------------------------------------------------------------------------
+// Header lines for output, ##fileformat first
+//------------------------------------------------------------------------------
+func (h *Header) Lines() []string {
+	lines := []string{"##fileformat=" + h.Fileformat}
+	for _, ml := range h.lines {
+		lines = append(lines, ml.line)
+	}
+	return lines
+}
+
+//------------------------------------------------------------------------------
+// Number of each INFO and FORMAT definition, keyed "INFO/ID" and "FORMAT/ID"
+//------------------------------------------------------------------------------
+func (h *Header) Numbers() map[string]string {
+	numbers := make(map[string]string)
+	for _, ml := range h.lines {
+		if ml.Key == "INFO" || ml.Key == "FORMAT" {
+			numbers[ml.Key+"/"+ml.ID()] = ml.Fields["Number"]
+		}
+	}
+	return numbers
+}
+
+//------------------------------------------------------------------------------
+// Contigs held, in header order
+//------------------------------------------------------------------------------
+func (h *Header) Contigs() []Contig {
+	return GetContigs(h.Lines())
+}
+
+//------------------------------------------------------------------------------
+// split on commas outside of double quotes
+//------------------------------------------------------------------------------
+func splitStructured(str string) []string {
+	elems := make([]string, 0)
+	inquote := false
+	start := 0
+	for i := 0; i < len(str); i++ {
+		switch str[i] {
+		case '\\':
+			i++
+		case '"':
+			inquote = !inquote
+		case ',':
+			if !inquote {
+				elems = append(elems, str[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(elems, str[start:])
+}
+
+func unquote(str string) string {
+	if len(str) >= 2 && str[0] == '"' && str[len(str)-1] == '"' {
+		return strings.Replace(str[1:len(str)-1], "\\\"", "\"", -1)
+	}
+	return str
+}
@@ -0,0 +1,675 @@
+// Opening VCF inputs and outputs: plain text, gzip or BGZF compressed VCF, and BCF
+package vcfio
+
+import (
+	"bcf"
+	"bgzf"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"region"
+	"strconv"
+	"strings"
+	"tabix"
+	"variant"
+	"vcfheader"
+)
+
+// Input compression, as found from the leading bytes
+const (
+	FormatPlain = "plain"
+	FormatGzip  = "gzip"
+	FormatBgzf  = "bgzf"
+	FormatBcf   = "bcf"
+)
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+//-----------------------------------------------
+// input: a decompressed input and the file beneath it
+//-----------------------------------------------
+type input struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (in *input) Close() error {
+	var err error
+	for i := len(in.closers) - 1; i >= 0; i-- {
+		if cerr := in.closers[i].Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+//------------------------------------------------------------------------------
+// Open an input, "-" is stdin: the format is sniffed from the magic bytes so
+// plain text, gzip and BGZF (read across all its members) are handled alike
+//------------------------------------------------------------------------------
+func Open(path string) (io.ReadCloser, string, error) {
+	var f *os.File
+	if path == "-" {
+		f = os.Stdin
+	} else {
+		var err error
+		if f, err = os.Open(path); err != nil {
+			return nil, "", err
+		}
+	}
+	return decompress(f, path)
+}
+
+// The decompressed stream of rc, closing it closes rc
+func decompress(rc io.ReadCloser, name string) (io.ReadCloser, string, error) {
+	br := bufio.NewReader(rc)
+	format, err := Sniff(br)
+	if err != nil && err != io.EOF {
+		rc.Close()
+		return nil, "", fmt.Errorf("%s: %v", name, err)
+	}
+	if format == FormatPlain {
+		return &input{Reader: br, closers: []io.Closer{rc}}, format, nil
+	}
+	gr, err := gzip.NewReader(br)
+	if err != nil {
+		rc.Close()
+		return nil, "", fmt.Errorf("%s: %v", name, err)
+	}
+	return &input{Reader: gr, closers: []io.Closer{rc, gr}}, format, nil
+}
+
+//-----------------------------------------------
+// Reader: source of header data and records
+//-----------------------------------------------
+type Reader interface {
+	MetaLines() []string
+	Samples() []string
+	Read() ([]string, error)
+	Close() error
+}
+
+//------------------------------------------------------------------------------
+// Open an input and read its header, VCF text or BCF (found from the
+// decompressed magic bytes), the format string describes compression and content
+//------------------------------------------------------------------------------
+func NewReader(path string) (Reader, string, error) {
+	rc, format, err := Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+	return new_reader(rc, format, path)
+}
+
+//------------------------------------------------------------------------------
+// A Reader over a stream, as NewReader, name stands for the path in errors
+// and closing the Reader closes rc
+//------------------------------------------------------------------------------
+func NewStreamReader(rc io.ReadCloser, name string) (Reader, string, error) {
+	in, format, err := decompress(rc, name)
+	if err != nil {
+		return nil, "", err
+	}
+	return new_reader(in, format, name)
+}
+
+func new_reader(rc io.ReadCloser, format string, path string) (Reader, string, error) {
+	br := bufio.NewReader(rc)
+	if magic, _ := br.Peek(len(bcf.Magic)); bytes.Equal(magic, bcf.Magic) {
+		r, err := bcf.NewReader(br)
+		if err != nil {
+			rc.Close()
+			return nil, "", fmt.Errorf("%s: %v", path, err)
+		}
+		return &bcfReader{Reader: r, c: rc, path: path}, format + "/" + FormatBcf, nil
+	}
+	tr := &textReader{r: br, c: rc, path: path}
+	if err := tr.readHeader(); err != nil {
+		rc.Close()
+		return nil, "", err
+	}
+	return tr, format, nil
+}
+
+// lineReader: bufio.Reader, or bgzf.Reader when records are sought to
+type lineReader interface {
+	ReadString(delim byte) (string, error)
+}
+
+//-----------------------------------------------
+// ParseError: a malformed input record, Line is its line in a VCF text
+// input (0 when not known: BCF, or records sought to through an index)
+//-----------------------------------------------
+type ParseError struct {
+	File string
+	Line int
+	Msg  string
+}
+
+func (e *ParseError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+	}
+	return fmt.Sprintf("%s: %s", e.File, e.Msg)
+}
+
+//-----------------------------------------------
+// textReader: VCF text records split on tabs, line counts the lines read
+// (-1 once records are sought to)
+//-----------------------------------------------
+type textReader struct {
+	r       lineReader
+	c       io.Closer
+	path    string
+	line    int
+	meta    []string
+	samples []string
+}
+
+//------------------------------------------------------------------------------
+// Get headers with column(sample) names, and the ## meta lines, a #CHROM
+// line without FORMAT (a sites-only VCF) is a ParseError
+//------------------------------------------------------------------------------
+func (tr *textReader) readHeader() error {
+	for {
+		text, err := tr.r.ReadString('\n')
+		tr.line++
+		text = strings.TrimRight(text, "\r\n")
+		if strings.HasPrefix(text, "##") {
+			tr.meta = append(tr.meta, text)
+		} else if strings.HasPrefix(text, "#") {
+			cols := strings.Split(text, "\t")
+			if len(cols) < 9 {
+				return &ParseError{File: tr.path, Line: tr.line, Msg: "no FORMAT column, sites-only VCF cannot be merged"}
+			}
+			_, tr.samples = variant.GetVCFPrfx_Sfx(cols)
+			return nil
+		}
+		if err == io.EOF {
+			return &ParseError{File: tr.path, Line: tr.line, Msg: "no #CHROM header line"}
+		} else if err != nil {
+			return fmt.Errorf("%s: %v", tr.path, err)
+		}
+	}
+}
+
+func (tr *textReader) MetaLines() []string {
+	return tr.meta
+}
+
+func (tr *textReader) Samples() []string {
+	return tr.samples
+}
+
+func (tr *textReader) Read() ([]string, error) {
+	for {
+		text, err := tr.r.ReadString('\n')
+		if tr.line >= 0 {
+			tr.line++
+		}
+		text = strings.TrimRight(text, "\r\n")
+		if text != "" {
+			rec := strings.Split(text, "\t")
+			if msg := tr.check(rec); msg != "" {
+				line := tr.line
+				if line < 0 {
+					line = 0
+				}
+				return nil, &ParseError{File: tr.path, Line: line, Msg: msg}
+			}
+			return rec, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// What is wrong with a record's columns, "" if nothing
+func (tr *textReader) check(rec []string) string {
+	if len(rec) != 9+len(tr.samples) {
+		return fmt.Sprintf("%d columns, expected %d for the %d header samples", len(rec), 9+len(tr.samples), len(tr.samples))
+	}
+	if posn, err := strconv.ParseInt(rec[1], 10, 64); err != nil || posn < 0 {
+		return fmt.Sprintf("POS %q is not a position", rec[1])
+	}
+	return ""
+}
+
+func (tr *textReader) Close() error {
+	return tr.c.Close()
+}
+
+//-----------------------------------------------
+// bcfReader: BCF records converted to text fields
+//-----------------------------------------------
+type bcfReader struct {
+	*bcf.Reader
+	c    io.Closer
+	path string
+}
+
+func (br *bcfReader) Read() ([]string, error) {
+	rec, err := br.Reader.Read()
+	if err != nil && err != io.EOF {
+		return nil, &ParseError{File: br.path, Msg: err.Error()}
+	}
+	if err == nil && len(rec) != 9+len(br.Reader.Samples) {
+		msg := fmt.Sprintf("record at %s:%s has no FORMAT fields, sites-only records cannot be merged", rec[0], rec[1])
+		return nil, &ParseError{File: br.path, Msg: msg}
+	}
+	return rec, err
+}
+
+func (br *bcfReader) MetaLines() []string {
+	return br.Meta
+}
+
+func (br *bcfReader) Samples() []string {
+	return br.Reader.Samples
+}
+
+func (br *bcfReader) Close() error {
+	return br.c.Close()
+}
+
+//------------------------------------------------------------------------------
+// Restrict a reader to records overlapping regions (as from region.Normalise,
+// records come back in region order): a BGZF input with a .csi or .tbi index
+// alongside is re-opened to seek to each region, otherwise records are
+// filtered as they are read. Returns whether the index was used.
+//------------------------------------------------------------------------------
+func Restrict(r Reader, path string, regions []region.Region) (Reader, bool, error) {
+	for _, ext := range []string{".csi", ".tbi"} {
+		if path == "-" {
+			break
+		}
+		fi, err := os.Open(path + ext)
+		if err != nil {
+			continue
+		}
+		idx, err := tabix.ReadIndex(fi)
+		fi.Close()
+		if err != nil {
+			return nil, false, fmt.Errorf("%s%s: %v", path, ext, err)
+		}
+		ir, err := newIndexedReader(path, idx, regions)
+		if err != nil {
+			return nil, false, fmt.Errorf("%s: %v", path, err)
+		}
+		r.Close()
+		return ir, true, nil
+	}
+	return &filterReader{Reader: r, regions: regions}, false, nil
+}
+
+//-----------------------------------------------
+// filterReader: records overlapping any region, read through
+//-----------------------------------------------
+type filterReader struct {
+	Reader
+	regions []region.Region
+}
+
+func (fr *filterReader) Read() ([]string, error) {
+	for {
+		rec, err := fr.Reader.Read()
+		if err != nil {
+			return nil, err
+		}
+		beg, end := RecordSpan(rec)
+		for _, reg := range fr.regions {
+			if reg.Overlaps(variant.GetChrom(rec), beg, end) {
+				return rec, nil
+			}
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
+// A reader giving the sample columns cols (of the underlying reader's samples)
+// alone, named names
+//------------------------------------------------------------------------------
+func SelectSamples(r Reader, names []string, cols []int) Reader {
+	return &sampleReader{Reader: r, names: names, cols: cols}
+}
+
+//-----------------------------------------------
+// sampleReader: records cut down to the selected sample columns
+//-----------------------------------------------
+type sampleReader struct {
+	Reader
+	names []string
+	cols  []int
+}
+
+func (sr *sampleReader) Samples() []string {
+	return sr.names
+}
+
+func (sr *sampleReader) Read() ([]string, error) {
+	rec, err := sr.Reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	prfx, sfx := variant.GetVCFPrfx_Sfx(rec)
+	selected := make([]string, len(prfx), len(prfx)+len(sr.cols))
+	copy(selected, prfx)
+	for _, col := range sr.cols {
+		if col >= len(sfx) {
+			return nil, fmt.Errorf("record at %s:%d has %d samples", variant.GetChrom(rec), variant.GetPosn(rec), len(sfx))
+		}
+		selected = append(selected, sfx[col])
+	}
+	return selected, nil
+}
+
+//-----------------------------------------------
+// indexedReader: seeks to the index chunks for each region in turn
+//-----------------------------------------------
+type indexedReader struct {
+	Reader
+	bz      *bgzf.Reader
+	idx     *tabix.Index
+	regions []region.Region
+	rix     int
+	chunks  []tabix.Chunk
+	cix     int
+	sought  bool
+}
+
+func newIndexedReader(path string, idx *tabix.Index, regions []region.Region) (*indexedReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	bz := bgzf.NewReader(f)
+	ir := &indexedReader{bz: bz, idx: idx, regions: regions, rix: -1}
+	magic := make([]byte, len(bcf.Magic))
+	_, err = io.ReadFull(bz, magic)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		f.Close()
+		return nil, err
+	}
+	if err := bz.Seek(0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if bytes.Equal(magic, bcf.Magic) {
+		r, err := bcf.NewReader(bz)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if len(idx.Names) == 0 {
+			idx.Names = r.Dict.Contigs
+		}
+		ir.Reader = &bcfReader{Reader: r, c: f, path: path}
+		return ir, nil
+	}
+	tr := &textReader{r: bz, c: f, path: path}
+	if err := tr.readHeader(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	tr.line = -1
+	ir.Reader = tr
+	return ir, nil
+}
+
+// index name for a region chromosome, allowing for leading zeros
+func (ir *indexedReader) indexName(chrom string) string {
+	for _, name := range ir.idx.Names {
+		if variant.SameChrom(name, chrom) {
+			return name
+		}
+	}
+	return chrom
+}
+
+func (ir *indexedReader) nextRegion() {
+	ir.rix++
+	ir.cix = 0
+	ir.sought = false
+	ir.chunks = nil
+	if ir.rix < len(ir.regions) {
+		reg := ir.regions[ir.rix]
+		ir.chunks = ir.idx.Query(ir.indexName(reg.Chrom), reg.Beg, reg.End)
+	}
+}
+
+//------------------------------------------------------------------------------
+// Next record overlapping the current region, a record that also overlaps
+// the previous region was returned for it and is skipped
+//------------------------------------------------------------------------------
+func (ir *indexedReader) Read() ([]string, error) {
+	if ir.rix < 0 {
+		ir.nextRegion()
+	}
+	for ir.rix < len(ir.regions) {
+		if ir.cix >= len(ir.chunks) {
+			ir.nextRegion()
+			continue
+		}
+		chunk := ir.chunks[ir.cix]
+		if !ir.sought {
+			if err := ir.bz.Seek(chunk.Beg); err != nil {
+				return nil, err
+			}
+			ir.sought = true
+		}
+		if ir.bz.Tell() >= chunk.End {
+			ir.cix++
+			ir.sought = false
+			continue
+		}
+		rec, err := ir.Reader.Read()
+		if err == io.EOF {
+			ir.cix = len(ir.chunks)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		reg := ir.regions[ir.rix]
+		beg, end := RecordSpan(rec)
+		if !variant.SameChrom(variant.GetChrom(rec), reg.Chrom) || beg >= reg.End {
+			// records are sorted, so later chunks are past the region too
+			ir.cix = len(ir.chunks)
+			continue
+		}
+		if !reg.Overlaps(reg.Chrom, beg, end) {
+			continue
+		}
+		if ir.rix > 0 && ir.regions[ir.rix-1].Overlaps(variant.GetChrom(rec), beg, end) {
+			continue
+		}
+		return rec, nil
+	}
+	return nil, io.EOF
+}
+
+//------------------------------------------------------------------------------
+// Compression format from the leading bytes of a reader, BGZF is gzip with
+// the BC extra subfield
+//------------------------------------------------------------------------------
+func Sniff(br *bufio.Reader) (string, error) {
+	magic, err := br.Peek(18)
+	if len(magic) < 2 || !bytes.Equal(magic[:2], gzipMagic) {
+		return FormatPlain, err
+	}
+	if len(magic) == 18 && magic[3]&0x04 != 0 && magic[12] == 'B' && magic[13] == 'C' {
+		return FormatBgzf, nil
+	}
+	return FormatGzip, nil
+}
+
+//-----------------------------------------------
+// Writer: destination for the merged header and records
+//-----------------------------------------------
+type Writer interface {
+	WriteHeader(lines []string) error
+	WriteRecord(rec []string) error
+	Close() error
+}
+
+//------------------------------------------------------------------------------
+// Create an output: "" or "-" is plain text on stdout, a .gz or .bgz path
+// is written BGZF compressed and indexed, a .bcf path is BCF (BGZF compressed,
+// csi indexed), any other path is plain text
+//------------------------------------------------------------------------------
+func Create(path string) (Writer, error) {
+	if path == "" || path == "-" {
+		return &textWriter{w: bufio.NewWriter(os.Stdout)}, nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasSuffix(path, ".gz") || strings.HasSuffix(path, ".bgz") {
+		return &bgzfWriter{path: path, f: f, bw: bgzf.NewWriter(f)}, nil
+	}
+	if strings.HasSuffix(path, ".bcf") {
+		return &bgzfWriter{path: path, f: f, bw: bgzf.NewWriter(f), binary: true}, nil
+	}
+	return &textWriter{w: bufio.NewWriter(f), c: f}, nil
+}
+
+//-----------------------------------------------
+// textWriter: uncompressed VCF
+//-----------------------------------------------
+type textWriter struct {
+	w *bufio.Writer
+	c io.Closer
+}
+
+func (tw *textWriter) WriteHeader(lines []string) error {
+	for _, line := range lines {
+		if _, err := tw.w.WriteString(line + "\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tw *textWriter) WriteRecord(rec []string) error {
+	_, err := tw.w.WriteString(strings.Join(rec, "\t") + "\n")
+	return err
+}
+
+func (tw *textWriter) Close() error {
+	err := tw.w.Flush()
+	if tw.c != nil {
+		if cerr := tw.c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+//-----------------------------------------------
+// bgzfWriter: BGZF compressed VCF or BCF, indexed as it is written,
+// the index (.tbi, or .csi for BCF and contigs beyond 2^29) is written on Close
+//-----------------------------------------------
+type bgzfWriter struct {
+	path   string
+	f      *os.File
+	bw     *bgzf.Writer
+	idx    *tabix.Builder
+	binary bool
+	bcfw   *bcf.Writer
+}
+
+func (bz *bgzfWriter) WriteHeader(lines []string) error {
+	max_len := int64(0)
+	contigs := vcfheader.GetContigs(lines)
+	for _, contig := range contigs {
+		if contig.Length > max_len {
+			max_len = contig.Length
+		}
+	}
+	bz.idx = tabix.NewBuilder(max_len)
+	if bz.binary {
+		if len(contigs) == 0 {
+			return fmt.Errorf("%s: BCF output needs ##contig lines and the header has none, "+
+				"give the contig order (--contigs) or write VCF", bz.path)
+		}
+		var err error
+		if bz.bcfw, err = bcf.NewWriter(bz.bw, lines); err != nil {
+			return err
+		}
+		bz.idx.SetRefs(bz.bcfw.Dict.Contigs)
+		return bz.bw.Flush()
+	}
+	for _, line := range lines {
+		if _, err := bz.bw.WriteString(line + "\n"); err != nil {
+			return err
+		}
+	}
+	// records start in a new block
+	return bz.bw.Flush()
+}
+
+func (bz *bgzfWriter) WriteRecord(rec []string) error {
+	vbeg := bz.bw.Tell()
+	if bz.binary {
+		if err := bz.bcfw.Write(rec); err != nil {
+			return fmt.Errorf("%s: record at %s:%d: %v, BCF output needs every contig, FILTER, INFO and "+
+				"FORMAT id declared in the header, write VCF instead", bz.path, variant.GetChrom(rec),
+				variant.GetPosn(rec), err)
+		}
+	} else if _, err := bz.bw.WriteString(strings.Join(rec, "\t") + "\n"); err != nil {
+		return err
+	}
+	beg, end := RecordSpan(rec)
+	if err := bz.idx.Add(variant.GetChrom(rec), beg, end, vbeg, bz.bw.Tell()); err != nil {
+		return fmt.Errorf("index %s: %v", bz.path, err)
+	}
+	return nil
+}
+
+func (bz *bgzfWriter) Close() error {
+	if err := bz.bw.Close(); err != nil {
+		return err
+	}
+	if err := bz.f.Close(); err != nil {
+		return err
+	}
+	if bz.idx == nil {
+		return nil
+	}
+	idxpath := bz.path + ".tbi"
+	write := bz.idx.WriteTBI
+	if bz.binary || bz.idx.NeedsCSI() {
+		idxpath = bz.path + ".csi"
+		write = bz.idx.WriteCSI
+	}
+	fi, err := os.Create(idxpath)
+	if err != nil {
+		return err
+	}
+	if err := write(fi); err != nil {
+		fi.Close()
+		return err
+	}
+	return fi.Close()
+}
+
+//------------------------------------------------------------------------------
+// 0-based half-open span of a record: POS to the end of REF, or to INFO END
+//------------------------------------------------------------------------------
+func RecordSpan(rec []string) (int64, int64) {
+	beg := variant.GetPosn(rec) - 1
+	ref, _ := variant.GetAlleles(rec)
+	end := beg + int64(len(ref))
+	for _, elem := range strings.Split(variant.GetInfo(rec), ";") {
+		if strings.HasPrefix(elem, "END=") {
+			if e, err := strconv.ParseInt(elem[4:], 10, 64); err == nil && e > end {
+				end = e
+			}
+		}
+	}
+	return beg, end
+}
@@ -0,0 +1,240 @@
+package vcfio
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"region"
+	"strings"
+	"testing"
+	"variant"
+)
+
+var testHeader = []string{
+	"##fileformat=VCFv4.2",
+	"##contig=<ID=1,length=100000>",
+	"##contig=<ID=2,length=100000>",
+	"##contig=<ID=3,length=100000>",
+	"##FORMAT=<ID=GT,Number=1,Type=String,Description=\"Genotype\">",
+	"#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\tFORMAT\tS1",
+}
+
+// records by contig and position, 1:190 a deletion spanning 190-259; contig
+// 3 is declared but has no records, so it is not in the index
+var testRecords = [][]string{
+	{"1", "100", "rs100", "A", "G", ".", "PASS", ".", "GT", "0/1"},
+	{"1", "150", "rs150", "C", "T", ".", "PASS", ".", "GT", "0/0"},
+	{"1", "190", "rs190", "A" + strings.Repeat("C", 69), "A", ".", "PASS", ".", "GT", "0/1"},
+	{"1", "250", "rs250", "G", "A", ".", "PASS", ".", "GT", "1/1"},
+	{"1", "300", "rs300", "T", "C", ".", "PASS", ".", "GT", "0/1"},
+	{"1", "5000", "rs5000", "A", "T", ".", "PASS", ".", "GT", "0/1"},
+	{"1", "60000", "rs60000", "A", "T", ".", "PASS", ".", "GT", "0/0"},
+	{"2", "10", "rs10", "G", "C", ".", "PASS", ".", "GT", "0/1"},
+	{"2", "20", "rs20", "G", "C", ".", "PASS", ".", "GT", "1/1"},
+}
+
+// Write the test records to path (indexed when BGZF or BCF)
+func write_test_vcf(t *testing.T, path string) {
+	t.Helper()
+	w, err := Create(path)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err := w.WriteHeader(testHeader); err != nil {
+		t.Fatalf("WriteHeader: %v", err)
+	}
+	for _, rec := range testRecords {
+		if err := w.WriteRecord(rec); err != nil {
+			t.Fatalf("WriteRecord: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+}
+
+// IDs of the records read from path restricted to regions, and whether
+// the index was used
+func read_regions(t *testing.T, path string, regions []region.Region) ([]string, bool) {
+	t.Helper()
+	r, _, err := NewReader(path)
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	restricted, indexed, err := Restrict(r, path, regions)
+	if err != nil {
+		t.Fatalf("Restrict: %v", err)
+	}
+	defer restricted.Close()
+	ids := make([]string, 0)
+	for {
+		rec, err := restricted.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+		ids = append(ids, variant.GetVarid(rec))
+	}
+	return ids, indexed
+}
+
+func parse_regions(t *testing.T, specs ...string) []region.Region {
+	t.Helper()
+	regions := make([]region.Region, 0, len(specs))
+	for _, spec := range specs {
+		reg, err := region.Parse(spec)
+		if err != nil {
+			t.Fatalf("region %s: %v", spec, err)
+		}
+		regions = append(regions, reg)
+	}
+	return regions
+}
+
+// The test file as indexed BGZF, indexed BCF, BGZF without an index and
+// plain text, and whether each is read through its index
+func test_files(t *testing.T) map[string]bool {
+	dir := t.TempDir()
+	files := map[string]bool{
+		filepath.Join(dir, "a.vcf.gz"): true,
+		filepath.Join(dir, "a.bcf"):    true,
+		filepath.Join(dir, "b.vcf.gz"): false,
+		filepath.Join(dir, "a.vcf"):    false,
+	}
+	for path := range files {
+		write_test_vcf(t, path)
+	}
+	if err := os.Remove(filepath.Join(dir, "b.vcf.gz.tbi")); err != nil {
+		t.Fatalf("remove index: %v", err)
+	}
+	return files
+}
+
+func TestRestrict(t *testing.T) {
+	order := variant.MakeContigOrder([]string{"1", "2", "3"})
+	tests := []struct {
+		name    string
+		regions []region.Region
+		want    []string
+	}{
+		{"one region", parse_regions(t, "1:120-260"),
+			[]string{"rs150", "rs190", "rs250"}},
+		{"whole contig", parse_regions(t, "2"),
+			[]string{"rs10", "rs20"}},
+		{"overlapping regions, normalised", region.Normalise(parse_regions(t, "1:200-300", "1:100-260", "1:250-5000"), order),
+			[]string{"rs100", "rs150", "rs190", "rs250", "rs300", "rs5000"}},
+		{"deletion spanning two regions", parse_regions(t, "1:100-200", "1:240-260"),
+			[]string{"rs100", "rs150", "rs190", "rs250"}},
+		{"regions across contigs", region.Normalise(parse_regions(t, "2:15-30", "1:4000-70000"), order),
+			[]string{"rs5000", "rs60000", "rs20"}},
+		{"contig not in the index", parse_regions(t, "1:90-110", "3:1-100000", "2:1-15"),
+			[]string{"rs100", "rs10"}},
+		{"contig not in the header", parse_regions(t, "X:1-100000", "2:15-30"),
+			[]string{"rs20"}},
+		{"only a contig not in the index", parse_regions(t, "3"),
+			[]string{}},
+		{"no records in region", parse_regions(t, "1:400-4000"),
+			[]string{}},
+	}
+	for path, want_indexed := range test_files(t) {
+		for _, tt := range tests {
+			t.Run(filepath.Base(path)+"/"+tt.name, func(t *testing.T) {
+				ids, indexed := read_regions(t, path, tt.regions)
+				if indexed != want_indexed {
+					t.Errorf("indexed %t, want %t", indexed, want_indexed)
+				}
+				if fmt.Sprint(ids) != fmt.Sprint(tt.want) {
+					t.Errorf("records %v, want %v", ids, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestReadHeaderErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		vcf  string
+		line int
+	}{
+		{"sites only", "##fileformat=VCFv4.2\n#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\n", 2},
+		// at end of file, the line after the last
+		{"no column header", "##fileformat=VCFv4.2\n", 2},
+	}
+	for _, tt := range tests {
+		_, _, err := NewStreamReader(io.NopCloser(strings.NewReader(tt.vcf)), "in.vcf")
+		perr, ok := err.(*ParseError)
+		if !ok {
+			t.Errorf("%s: error %v, want a *ParseError", tt.name, err)
+			continue
+		}
+		if perr.File != "in.vcf" || perr.Line != tt.line {
+			t.Errorf("%s: error at %s:%d, want in.vcf:%d", tt.name, perr.File, perr.Line, tt.line)
+		}
+	}
+}
+
+func TestReadRecordErrors(t *testing.T) {
+	header := strings.Join(testHeader, "\n") + "\n"
+	for _, rec := range []string{
+		"1\t100\trs1\tA\tG\t.\tPASS\t.\tGT\n",
+		"1\t100\trs1\tA\tG\t.\tPASS\t.\tGT\t0/1\t0/1\n",
+		"1\tx\trs1\tA\tG\t.\tPASS\t.\tGT\t0/1\n",
+		"1\t-5\trs1\tA\tG\t.\tPASS\t.\tGT\t0/1\n",
+	} {
+		r, _, err := NewStreamReader(io.NopCloser(strings.NewReader(header+test_record_line(0)+rec)), "in.vcf")
+		if err != nil {
+			t.Fatalf("NewStreamReader: %v", err)
+		}
+		if _, err := r.Read(); err != nil {
+			t.Fatalf("first record: %v", err)
+		}
+		_, err = r.Read()
+		perr, ok := err.(*ParseError)
+		if !ok || perr.Line != len(testHeader)+2 {
+			t.Errorf("record %q: error %v, want a *ParseError at line %d", rec, err, len(testHeader)+2)
+		}
+	}
+}
+
+// A test record as a text line
+func test_record_line(i int) string {
+	return strings.Join(testRecords[i], "\t") + "\n"
+}
+
+func TestBcfUndeclared(t *testing.T) {
+	no_contigs := []string{testHeader[0], testHeader[4], testHeader[5]}
+	tests := []struct {
+		name   string
+		header []string
+		rec    []string
+		want   string
+	}{
+		{"no contig lines", no_contigs, nil, "BCF output needs ##contig lines"},
+		{"contig", testHeader, []string{"4", "10", ".", "G", "C", ".", "PASS", ".", "GT", "0/1"},
+			"record at 4:10: contig 4 not in header"},
+		{"FORMAT", testHeader, []string{"1", "10", ".", "G", "C", ".", "PASS", ".", "GT:DS", "0/1:1"},
+			"record at 1:10: FORMAT DS not in header"},
+		{"INFO", testHeader, []string{"2", "20", ".", "G", "C", ".", "PASS", "AF=0.2", "GT", "0/1"},
+			"record at 2:20: INFO AF not in header"},
+		{"FILTER", testHeader, []string{"1", "30", ".", "G", "C", ".", "LowQual", ".", "GT", "0/1"},
+			"record at 1:30: FILTER LowQual not in header"},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "out.bcf")
+		w, err := Create(path)
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		err = w.WriteHeader(tt.header)
+		if err == nil && tt.rec != nil {
+			err = w.WriteRecord(tt.rec)
+		}
+		w.Close()
+		if err == nil || !strings.HasPrefix(err.Error(), path) || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error %v, want %s", tt.name, err, tt.want)
+		}
+	}
+}
@@ -1,10 +1,10 @@
 package vcfmerge
 
 import (
-	//"fmt"
+	"fmt"
 	"genometrics"
-	"log"
 	"sort"
+	"strconv"
 	"strings"
 	"variant"
 )
@@ -14,23 +14,15 @@ import (
 //-----------------------------------------------
 type Vcfdata struct {
 	Assaytype string
+	Abbrev    string
 	Probidx   int
 	Callrate  float64
 	Infoscore float64
+	Filters   []string
 }
 
 const hdr_prfx = "#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\tFORMAT\t"
 
-var assayTypeAbbreviation = map[string]string{
-	"affy":        "A",
-	"illumina":    "I",
-	"affy1KG":     "A1",
-	"illumina1KG": "I1",
-	"broad":       "B",
-	"metabo":      "M",
-	"exome":       "E",
-}
-
 func GetCombinedColumnHeaders(sample_name_map map[string]int) (string, []string) {
 
 	posns := make(map[int]string, len(sample_name_map))
@@ -100,14 +92,28 @@ func Mergeslices(vcfset [][]string, sample_posns map[string]map[int]string,
 // Merge version II: build out full results arrays for each assay in the vcfset,
 // then process in lockstep to allow comparision of all genotypes for the same
 // sample at the same time
+// All records in the vcfset share a composite key (CHROM, POS, REF, ALT), rsid
+// is the variant id to carry into the merged record
+// vcfdataset (when given) holds QC data for each vcfset record, records with
+// Filters set contribute no genotypes, their filters are set in FILTER only
+// when every assay failed (a passing assay's genotypes are not filtered)
+// resolver chooses the genotype of a sample typed by more than one assay
+// Sample values are remapped by key to the merged FORMAT (the union of the
+// assays' FORMAT keys, see merged_layout), DS is recomputed from the GP
+// carried, and samples not typed are padded missing
+// ploidies (nil for all diploid) gives the ploidy of each combined column,
+// haploid samples are called and padded as haploid, ploidy 0 samples missing
 //------------------------------------------------------------------------------
 func Mergeslices_full(vcfset [][]string, vcfdataset []Vcfdata, rsid string,
 	sample_names_by_posn map[string]map[int]string, combo_posns map[string]int,
-	combo_names []string, threshold float64, gmetrics *genometrics.AllMetrics) []string {
+	combo_names []string, threshold float64, resolver Resolver, ploidies []int,
+	gmetrics *genometrics.AllMetrics) []string {
 
 	var prfx []string
 	var sfx []string
-	probidx := 1
+	layout := merged_layout(vcfset, vcfdataset)
+	probidx := fieldIndex(layout, "GP")
+	dsidx := fieldIndex(layout, "DS")
 
 	comborec := make([]string, len(combo_posns))
 	for i, _ := range comborec {
@@ -115,41 +121,51 @@ func Mergeslices_full(vcfset [][]string, vcfdataset []Vcfdata, rsid string,
 	}
 
 	assayrecs := make([][]string, 0, len(vcfset))
+	assaydata := make([]Vcfdata, 0, len(vcfset))
 	atypes := make([]string, 0, len(vcfset))
 
-	savedVarid := ""
-	savedRefAllele := ""
-	savedAltAllele := ""
-
-	if len(vcfset) > 0 {
-		savedVarid = variant.GetVarid(vcfset[0][1:])
-		savedRefAllele, savedAltAllele = variant.GetAlleles(vcfset[0][1:])
-	}
-
-	for _, rec := range vcfset {
+	filters := make([]string, 0)
+	for k, rec := range vcfset {
 		atype := rec[0]
 		currec := make([]string, len(combo_posns))
 		atypes = append(atypes, atype)
 		prfx, sfx = variant.GetVCFPrfx_Sfx(rec[1:])
-		probidx = variant.GetProbidx(prfx)
-		varid := variant.GetVarid(prfx)
-		refAllele, altAllele := variant.GetAlleles(prfx)
-		if (varid == savedVarid) && (refAllele == savedRefAllele) && (altAllele == savedAltAllele) {
-			for j, elem := range sfx {
-				currec[combo_posns[sample_names_by_posn[atype][j]]] = appendAssayAbbrev(elem, atype)
-			}
-			assayrecs = append(assayrecs, currec)
-		} else {
-			log.Printf("REJ: merge mismatch: %v (%s, %s, %s)\n", prfx, savedVarid, savedRefAllele, savedAltAllele)
+		if len(vcfdataset) == len(vcfset) && len(vcfdataset[k].Filters) > 0 {
+			filters = appendUnique(filters, vcfdataset[k].Filters...)
+			continue
+		}
+		vd := Vcfdata{Assaytype: atype}
+		if len(vcfdataset) == len(vcfset) {
+			vd = vcfdataset[k]
+		}
+		format := strings.Split(variant.GetFormat(prfx), ":")
+		for j, elem := range sfx {
+			currec[combo_posns[sample_names_by_posn[atype][j]]] = appendAssayAbbrev(remap_sample(elem, format, layout), vd.atAbbrev())
 		}
+		assayrecs = append(assayrecs, currec)
+		assaydata = append(assaydata, vd)
 	}
+	_, alt := variant.GetAlleles(prfx)
+	nalleles := len(strings.Split(alt, ",")) + 1
 	// At this point all "input" genotype data has been captured - now
 	// Look at each possible genotype for the comborec
 	for i, _ := range comborec {
+		ploidy := 2
+		if i < len(ploidies) {
+			ploidy = ploidies[i]
+		}
+		if ploidy == 0 {
+			comborec[i] = missing_sample(layout, ploidy)
+			continue
+		}
 		geno_list := make([]string, 0, len(assayrecs))
-		for _, genos := range assayrecs {
+		calls := make([]Call, 0, len(assayrecs))
+		for k, genos := range assayrecs {
 			if genos[i] != "" {
-				geno_list = append(geno_list, variant.Get_geno(genos[i], threshold, probidx))
+				geno := call_geno(genos[i], threshold, probidx, ploidy, nalleles)
+				geno_list = append(geno_list, geno)
+				calls = append(calls, Call{Geno: geno, Assaytype: assaydata[k].Assaytype, Abbrev: assaydata[k].atAbbrev(),
+					Infoscore: assaydata[k].Infoscore, Ploidy: ploidy})
 			}
 		}
 		//fmt.Printf("%s: %s\n", combo_names[i], geno_list)
@@ -164,39 +180,203 @@ func Mergeslices_full(vcfset [][]string, vcfdataset []Vcfdata, rsid string,
 				//fmt.Printf("OVERLAP_GT2 %s:%s - %s\n", rsid, combo_names[i], geno_list)
 				(*gmetrics).GtTwoOverlapCount++
 			}
-			comborec[i] = get_best_geno(geno_list, probidx, rsid, gmetrics)
+			count_discordance(calls, probidx, gmetrics)
+			count_concordance(combo_names[i], calls, gmetrics)
+			comborec[i] = resolver.Resolve(calls, probidx, dsidx)
+			if comborec[i] == "." || missing_gt(strings.Split(comborec[i], ":")[0]) {
+				(*gmetrics).MissingCount += 1
+			}
 		} else {
 			if len(geno_list) == 1 {
 				comborec[i] = geno_list[0]
 			}
 		}
+		if comborec[i] == "." {
+			comborec[i] = missing_sample(layout, ploidy)
+		} else {
+			comborec[i] = set_dosage(comborec[i], probidx, dsidx, ploidy)
+		}
 	}
+	prfx = variant.SetFormat(prfx, strings.Join(layout, ":"))
 	prfx = variant.AppendToFmt(prfx, "AT")
+	if len(filters) > 0 && len(assayrecs) == 0 {
+		prfx = variant.SetFilter(prfx, strings.Join(filters, ";"))
+	}
+	if rsid != "" {
+		prfx = variant.SetVarid(prfx, rsid)
+	}
 	// no leading chr zeros
 	prfx = variant.NormaliseChromosome(prfx)
 	return append(prfx, comborec...)
 }
 
 //------------------------------------------------------------------------------
-// Equality test for genotypes
+// FORMAT keys of the merged record, AT follows them: GT, then DS and GP if
+// any assay carries either (DS is recomputed from GP), then the other keys
+// of the assays contributing genotypes in the order first seen
+//------------------------------------------------------------------------------
+func merged_layout(vcfset [][]string, vcfdataset []Vcfdata) []string {
+	layout := []string{"GT"}
+	others := make([]string, 0)
+	dosage := false
+	for k, rec := range vcfset {
+		if len(vcfdataset) == len(vcfset) && len(vcfdataset[k].Filters) > 0 {
+			continue
+		}
+		prfx, _ := variant.GetVCFPrfx_Sfx(rec[1:])
+		for _, key := range strings.Split(variant.GetFormat(prfx), ":") {
+			switch key {
+			case "GT", "AT":
+			case "DS", "GP":
+				dosage = true
+			default:
+				others = appendUnique(others, key)
+			}
+		}
+	}
+	if dosage {
+		layout = append(layout, "DS", "GP")
+	}
+	return append(layout, others...)
+}
+
+func fieldIndex(layout []string, key string) int {
+	for i, k := range layout {
+		if k == key {
+			return i
+		}
+	}
+	return -9
+}
+
+//------------------------------------------------------------------------------
+// A sample's values in the order of layout, found by key in the record's
+// FORMAT, absent values are ".", a missing GT is "./."
+//------------------------------------------------------------------------------
+func remap_sample(value string, format []string, layout []string) string {
+	values := strings.Split(value, ":")
+	remapped := make([]string, len(layout))
+	for i, key := range layout {
+		remapped[i] = "."
+		if j := fieldIndex(format, key); j >= 0 && j < len(values) && values[j] != "" {
+			remapped[i] = values[j]
+		}
+	}
+	if remapped[0] == "." {
+		remapped[0] = "./."
+	}
+	return strings.Join(remapped, ":")
+}
+
+//------------------------------------------------------------------------------
+// A sample not typed by any assay: every field missing, shaped as the layout
+//------------------------------------------------------------------------------
+func missing_sample(layout []string, ploidy int) string {
+	missing := make([]string, len(layout)+1)
+	for i := range missing {
+		missing[i] = "."
+	}
+	if ploidy == 2 {
+		missing[0] = "./."
+	}
+	return strings.Join(missing, ":")
+}
+
+// Is every allele of a GT missing
+func missing_gt(gt string) bool {
+	return strings.Trim(gt, "./|") == ""
+}
+
+//------------------------------------------------------------------------------
+// GT called from GP at the threshold (variant.Get_geno_ploidy), a diploid
+// value without GP keeps its GT
+//------------------------------------------------------------------------------
+func call_geno(geno string, threshold float64, probidx int, ploidy int, nalleles int) string {
+	if ploidy == 1 {
+		return variant.Get_geno_ploidy(geno, threshold, probidx, ploidy, nalleles)
+	}
+	genodata := strings.Split(geno, ":")
+	if probidx < 0 || probidx >= len(genodata) {
+		return geno
+	}
+	if _, ok := parseProbs(genodata[probidx]); !ok {
+		return geno
+	}
+	return variant.Get_geno(geno, threshold, probidx)
+}
+
+//------------------------------------------------------------------------------
+// DS set to the expected count of each ALT allele from the GP carried
+// (diploid GP in VCF genotype order, so any number of alleles, haploid GP
+// one per allele)
+//------------------------------------------------------------------------------
+func set_dosage(geno string, probidx int, dsidx int, ploidy int) string {
+	genodata := strings.Split(geno, ":")
+	if probidx < 0 || probidx >= len(genodata) || dsidx < 0 || dsidx >= len(genodata) {
+		return geno
+	}
+	probs, ok := parseProbs(genodata[probidx])
+	nalleles := variant.AllelesForGenotypes(len(probs))
+	if ploidy == 1 {
+		nalleles = len(probs)
+	}
+	if !ok || nalleles < 2 {
+		return geno
+	}
+	dosages := make([]float64, nalleles)
+	for idx, prob := range probs {
+		if ploidy == 1 {
+			dosages[idx] += prob
+			continue
+		}
+		j, k := variant.GenotypeFromIndex(idx)
+		dosages[j] += prob
+		dosages[k] += prob
+	}
+	ds := make([]string, 0, nalleles-1)
+	for _, dosage := range dosages[1:] {
+		ds = append(ds, strconv.FormatFloat(dosage, 'f', 3, 64))
+	}
+	genodata[dsidx] = strings.Join(ds, ",")
+	return strings.Join(genodata, ":")
+}
+
+func appendUnique(list []string, elems ...string) []string {
+	for _, elem := range elems {
+		found := false
+		for _, l := range list {
+			if l == elem {
+				found = true
+			}
+		}
+		if !found {
+			list = append(list, elem)
+		}
+	}
+	return list
+}
+
+//------------------------------------------------------------------------------
+// Equality test for genotypes, phase is ignored (0|1 equals 1|0)
 //------------------------------------------------------------------------------
 func areEqual(geno1 string, geno2 string) bool {
 	g1 := strings.Split(geno1, ":")
 	g2 := strings.Split(geno2, ":")
-	if g1[0] == g2[0] {
-		return true
-	}
-	return false
+	return variant.SameGenotype(g1[0], g2[0])
 }
 
 //------------------------------------------------------------------------------
 //------------------------------------------------------------------------------
-func appendAssayAbbrev(geno string, assaytype string) string {
-	if v, ok := assayTypeAbbreviation[assaytype]; ok {
-		return geno + ":" + v
-	} else {
-		return geno
+func appendAssayAbbrev(geno string, abbrev string) string {
+	return geno + ":" + abbrev
+}
+
+// AT value for an assay record, the assay type itself if it has no abbreviation
+func (vd Vcfdata) atAbbrev() string {
+	if vd.Abbrev != "" {
+		return vd.Abbrev
 	}
+	return vd.Assaytype
 }
 
 //------------------------------------------------------------------------------
@@ -214,32 +394,352 @@ func resolveGeno(geno1 string, geno2 string, probidx int) string {
 }
 
 //------------------------------------------------------------------------------
+// Metrics for the calls of one sample, counted against the highest
+// posterior call so far whatever the resolver, and phase compared for
+// phased heterozygous calls of the same genotype
 //------------------------------------------------------------------------------
-func get_best_geno(geno_list []string, probidx int, varid string, gmetrics *genometrics.AllMetrics) string {
+func count_discordance(calls []Call, probidx int, gmetrics *genometrics.AllMetrics) {
+	count_phase(calls, gmetrics)
 	rgeno := ""
-	bgeno := "."
 	best_prob := 0.0
+	for _, call := range calls {
+		if variant.SameGenotype(call.GT(), rgeno) {
+			continue
+		}
+		if rgeno != "" {
+			(*gmetrics).MismatchCount += 1
+		}
+		if missing_gt(call.GT()) {
+			(*gmetrics).MissTestCount += 1
+		}
+		if prob, _, _ := variant.MaxProb(call.Geno, probidx); prob > best_prob {
+			rgeno = call.GT()
+			best_prob = prob
+		}
+	}
+}
+
+// Each pair of calls of a sample counted in the per-sample concordance
+func count_concordance(sample string, calls []Call, gmetrics *genometrics.AllMetrics) {
+	for a := 0; a < len(calls); a++ {
+		for b := a + 1; b < len(calls); b++ {
+			gmetrics.AddConcordance(sample, calls[a].Assaytype, calls[a].GT(), calls[b].Assaytype, calls[b].GT())
+		}
+	}
+}
 
-	for _, geno := range geno_list {
-		genodata := strings.Split(geno, ":")
-		if genodata[0] != rgeno {
-			if rgeno != "" {
-				(*gmetrics).MismatchCount += 1
+// Phased het calls compared with the first phased het call of the same
+// genotype, counting those with the opposite phase
+func count_phase(calls []Call, gmetrics *genometrics.AllMetrics) {
+	pgt := ""
+	for _, call := range calls {
+		gt := call.GT()
+		// homozygous calls (a haploid GT) have no phase to compare
+		if call.missing() || !variant.IsPhased(gt) || variant.HaploidGenotype(gt) != "." {
+			continue
+		}
+		if pgt == "" {
+			pgt = gt
+		} else if variant.SameGenotype(gt, pgt) {
+			(*gmetrics).PhaseTestCount += 1
+			if gt != pgt {
+				(*gmetrics).PhaseDiffCount += 1
 			}
-			if genodata[0] == "./." {
-				(*gmetrics).MissTestCount += 1
+		}
+	}
+}
+
+//-----------------------------------------------
+// Call: one assay's genotype (FORMAT values joined by ':', GT called from
+// GP at the threshold) for a sample at the merged site
+//-----------------------------------------------
+type Call struct {
+	Geno      string
+	Assaytype string
+	Abbrev    string
+	Infoscore float64
+	Ploidy    int
+}
+
+func (c Call) GT() string {
+	return strings.SplitN(c.Geno, ":", 2)[0]
+}
+
+func (c Call) missing() bool {
+	return c.Geno == "." || missing_gt(c.GT())
+}
+
+//-----------------------------------------------
+// Resolver: chooses the merged genotype for a sample typed by more than one
+// assay
+//-----------------------------------------------
+type Resolver interface {
+	Name() string
+	Resolve(calls []Call, probidx int, dsidx int) string
+}
+
+// Resolver names, for --resolver
+const (
+	ResolvePosterior = "posterior"
+	ResolvePriority  = "priority"
+	ResolveMajority  = "majority"
+	ResolveMissing   = "missing"
+	ResolveInfo      = "info"
+	ResolveMean      = "mean"
+	ResolveInfoMean  = "info-mean"
+)
+
+var ResolverNames = []string{ResolvePosterior, ResolvePriority, ResolveMajority, ResolveMissing, ResolveInfo,
+	ResolveMean, ResolveInfoMean}
+
+//------------------------------------------------------------------------------
+// Resolver by name, priority is the assay order (the template file order),
+// threshold is the GP needed to call a genotype from averaged GPs
+//------------------------------------------------------------------------------
+func NewResolver(name string, priority []string, threshold float64) (Resolver, error) {
+	switch name {
+	case ResolvePosterior:
+		return posteriorResolver{}, nil
+	case ResolvePriority:
+		rank := make(map[string]int, len(priority))
+		for i, at := range priority {
+			rank[at] = i
+		}
+		return priorityResolver{rank: rank}, nil
+	case ResolveMajority:
+		return majorityResolver{}, nil
+	case ResolveMissing:
+		return missingResolver{}, nil
+	case ResolveInfo:
+		return infoResolver{}, nil
+	case ResolveMean:
+		return meanResolver{threshold: threshold, weight: unweighted, name: ResolveMean}, nil
+	case ResolveInfoMean:
+		return meanResolver{threshold: threshold, weight: info_weight, name: ResolveInfoMean}, nil
+	}
+	return nil, fmt.Errorf("unknown resolver %q, expected one of %s", name, strings.Join(ResolverNames, ", "))
+}
+
+//------------------------------------------------------------------------------
+// The call with the highest weighted max GP, "." if no call has a positive
+// weighted probability, of calls with the same GT the first is kept
+//------------------------------------------------------------------------------
+func best_call(calls []Call, probidx int, weight func(Call) float64) string {
+	rgeno := ""
+	bgeno := "."
+	best_prob := 0.0
+	for _, call := range calls {
+		if variant.SameGenotype(call.GT(), rgeno) {
+			continue
+		}
+		prob, _, _ := variant.MaxProb(call.Geno, probidx)
+		if prob*weight(call) > best_prob {
+			rgeno = call.GT()
+			bgeno = call.Geno
+			best_prob = prob * weight(call)
+		}
+	}
+	if bgeno == "." {
+		// no GP to compare, keep the first call made
+		for _, call := range calls {
+			if !call.missing() {
+				return call.Geno
 			}
-			prob, _, _ := variant.MaxProb(geno, probidx)
-			if prob > best_prob {
-				rgeno = genodata[0]
-				bgeno = geno
-				best_prob = prob
+		}
+	}
+	return bgeno
+}
+
+func unweighted(Call) float64 {
+	return 1.0
+}
+
+//-----------------------------------------------
+// posteriorResolver: the call with the highest max GP
+//-----------------------------------------------
+type posteriorResolver struct{}
+
+func (posteriorResolver) Name() string {
+	return ResolvePosterior
+}
+
+func (posteriorResolver) Resolve(calls []Call, probidx int, dsidx int) string {
+	return best_call(calls, probidx, unweighted)
+}
+
+//-----------------------------------------------
+// priorityResolver: the non-missing call from the highest priority assay
+//-----------------------------------------------
+type priorityResolver struct {
+	rank map[string]int
+}
+
+func (priorityResolver) Name() string {
+	return ResolvePriority
+}
+
+func (pr priorityResolver) Resolve(calls []Call, probidx int, dsidx int) string {
+	best := -1
+	for i, call := range calls {
+		if best < 0 || (calls[best].missing() && !call.missing()) ||
+			(calls[best].missing() == call.missing() && pr.rank[call.Assaytype] < pr.rank[calls[best].Assaytype]) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return "."
+	}
+	return calls[best].Geno
+}
+
+//-----------------------------------------------
+// majorityResolver: the most frequent non-missing GT (phase ignored), ties
+// broken by posterior, the highest posterior call with that GT is kept
+//-----------------------------------------------
+type majorityResolver struct{}
+
+func (majorityResolver) Name() string {
+	return ResolveMajority
+}
+
+func (majorityResolver) Resolve(calls []Call, probidx int, dsidx int) string {
+	votes := make(map[string]int)
+	most := 0
+	for _, call := range calls {
+		if !call.missing() {
+			gt := variant.UnphasedGenotype(call.GT())
+			votes[gt]++
+			if votes[gt] > most {
+				most = votes[gt]
 			}
 		}
 	}
-	bgenodata := strings.Split(bgeno, ":")
-	if (bgeno == ".") || (bgenodata[0] == "./.") {
-		(*gmetrics).MissingCount += 1
+	if most == 0 {
+		return best_call(calls, probidx, unweighted)
 	}
-	return bgeno
+	winners := make([]Call, 0, len(calls))
+	for _, call := range calls {
+		if !call.missing() && votes[variant.UnphasedGenotype(call.GT())] == most {
+			winners = append(winners, call)
+		}
+	}
+	return best_call(winners, probidx, unweighted)
+}
+
+//-----------------------------------------------
+// missingResolver: GT set missing when the non-missing calls disagree,
+// otherwise the highest posterior call
+//-----------------------------------------------
+type missingResolver struct{}
+
+func (missingResolver) Name() string {
+	return ResolveMissing
+}
+
+func (missingResolver) Resolve(calls []Call, probidx int, dsidx int) string {
+	gt := ""
+	for _, call := range calls {
+		if call.missing() {
+			continue
+		}
+		if gt != "" && !variant.SameGenotype(call.GT(), gt) {
+			bgenodata := strings.Split(best_call(calls, probidx, unweighted), ":")
+			bgenodata[0] = strings.Split(missing_sample(nil, call.Ploidy), ":")[0]
+			return strings.Join(bgenodata, ":")
+		}
+		gt = call.GT()
+	}
+	return best_call(calls, probidx, unweighted)
+}
+
+//-----------------------------------------------
+// infoResolver: the call with the highest max GP weighted by the assay
+// record's INFO score (a record without one, e.g. directly typed, has weight 1)
+//-----------------------------------------------
+type infoResolver struct{}
+
+func (infoResolver) Name() string {
+	return ResolveInfo
+}
+
+func (infoResolver) Resolve(calls []Call, probidx int, dsidx int) string {
+	return best_call(calls, probidx, info_weight)
+}
+
+func info_weight(call Call) float64 {
+	if call.Infoscore > 0.0 {
+		return call.Infoscore
+	}
+	return 1.0
+}
+
+//-----------------------------------------------
+// meanResolver: GP vectors averaged across the calls (straight or weighted
+// by INFO score) and renormalised, DS recomputed from the mean GP, and GT
+// called from it at the threshold, as variant.Get_geno. Other fields are
+// those of the first call, AT lists every assay averaged
+//-----------------------------------------------
+type meanResolver struct {
+	threshold float64
+	weight    func(Call) float64
+	name      string
+}
+
+func (mr meanResolver) Name() string {
+	return mr.name
+}
+
+func (mr meanResolver) Resolve(calls []Call, probidx int, dsidx int) string {
+	var mean []float64
+	var base []string
+	abbrevs := make([]string, 0, len(calls))
+	for _, call := range calls {
+		genodata := strings.Split(call.Geno, ":")
+		if probidx < 0 || probidx >= len(genodata) {
+			continue
+		}
+		probs, ok := parseProbs(genodata[probidx])
+		if !ok || (mean != nil && len(probs) != len(mean)) {
+			continue
+		}
+		if mean == nil {
+			mean = make([]float64, len(probs))
+			base = genodata
+		}
+		w := mr.weight(call)
+		for i, prob := range probs {
+			mean[i] += w * prob
+		}
+		abbrevs = append(abbrevs, call.Abbrev)
+	}
+	total := 0.0
+	for _, prob := range mean {
+		total += prob
+	}
+	if total <= 0.0 {
+		return best_call(calls, probidx, unweighted)
+	}
+	gps := make([]string, len(mean))
+	for i := range mean {
+		gps[i] = strconv.FormatFloat(mean[i]/total, 'f', 3, 64)
+	}
+	base[probidx] = strings.Join(gps, ",")
+	base[len(base)-1] = strings.Join(abbrevs, "+")
+	ploidy := calls[0].Ploidy
+	geno := variant.Get_geno_ploidy(strings.Join(base, ":"), mr.threshold, probidx, ploidy, len(mean))
+	return set_dosage(geno, probidx, dsidx, ploidy)
+}
+
+// GP values, false if any is missing or not a number
+func parseProbs(str string) ([]float64, bool) {
+	fields := strings.Split(str, ",")
+	probs := make([]float64, len(fields))
+	for i, field := range fields {
+		prob, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return nil, false
+		}
+		probs[i] = prob
+	}
+	return probs, true
 }
@@ -0,0 +1,101 @@
+package vcfmerge
+
+import (
+	"testing"
+)
+
+// Calls of assays a, b and c as GT:DS:GP:AT, GP at index 2 and DS at 1
+func test_calls(infoscores []float64, genos ...string) []Call {
+	calls := make([]Call, len(genos))
+	for i, geno := range genos {
+		at := string(rune('a' + i))
+		calls[i] = Call{Geno: geno, Assaytype: at, Abbrev: string(rune('A' + i)), Ploidy: 2}
+		if i < len(infoscores) {
+			calls[i].Infoscore = infoscores[i]
+		}
+	}
+	return calls
+}
+
+func TestResolvers(t *testing.T) {
+	disagree := test_calls([]float64{0.5, 0.99},
+		"0/1:1.000:0.1,0.85,0.05:A",
+		"1/1:1.900:0,0.1,0.9:B")
+	two_to_one := test_calls(nil,
+		"0/1:1.000:0.1,0.8,0.1:A",
+		"0/1:0.900:0.2,0.7,0.1:B",
+		"1/1:1.950:0,0.05,0.95:C")
+	one_missing := test_calls(nil,
+		"./.:.:.:A",
+		"0/1:1.000:0,1,0:B")
+	phased := test_calls(nil,
+		"0|1:1.000:0,0.95,0.05:A",
+		"1|0:1.000:0,0.99,0.01:B")
+	agree := test_calls([]float64{0.9, 0.3},
+		"1/1:1.950:0,0.05,0.95:A",
+		"1/1:2.000:0,0,1:B")
+	tests := []struct {
+		resolver string
+		calls    []Call
+		want     string
+	}{
+		{ResolvePosterior, disagree, "1/1:1.900:0,0.1,0.9:B"},
+		{ResolvePosterior, two_to_one, "1/1:1.950:0,0.05,0.95:C"},
+		{ResolvePosterior, one_missing, "0/1:1.000:0,1,0:B"},
+		{ResolvePosterior, phased, "0|1:1.000:0,0.95,0.05:A"},
+
+		{ResolvePriority, disagree, "0/1:1.000:0.1,0.85,0.05:A"},
+		{ResolvePriority, two_to_one, "0/1:1.000:0.1,0.8,0.1:A"},
+		{ResolvePriority, one_missing, "0/1:1.000:0,1,0:B"},
+
+		{ResolveMajority, disagree, "1/1:1.900:0,0.1,0.9:B"},
+		{ResolveMajority, two_to_one, "0/1:1.000:0.1,0.8,0.1:A"},
+		{ResolveMajority, one_missing, "0/1:1.000:0,1,0:B"},
+
+		{ResolveMissing, disagree, "./.:1.900:0,0.1,0.9:B"},
+		{ResolveMissing, two_to_one, "./.:1.950:0,0.05,0.95:C"},
+		{ResolveMissing, one_missing, "0/1:1.000:0,1,0:B"},
+		{ResolveMissing, phased, "0|1:1.000:0,0.95,0.05:A"},
+
+		{ResolveInfo, disagree, "1/1:1.900:0,0.1,0.9:B"},
+		{ResolveInfo, agree, "1/1:1.950:0,0.05,0.95:A"},
+		{ResolveInfo, one_missing, "0/1:1.000:0,1,0:B"},
+
+		{ResolveMean, disagree, "./.:1.425:0.050,0.475,0.475:A+B"},
+		{ResolveMean, agree, "1/1:1.975:0.000,0.025,0.975:A+B"},
+		{ResolveMean, one_missing, "0/1:1.000:0.000,1.000,0.000:B"},
+		{ResolveMean, phased, "0|1:1.030:0.000,0.970,0.030:A+B"},
+
+		{ResolveInfoMean, disagree, "./.:1.582:0.034,0.352,0.615:A+B"},
+		{ResolveInfoMean, agree, "1/1:1.964:0.000,0.038,0.963:A+B"},
+	}
+	for _, tt := range tests {
+		r, err := NewResolver(tt.resolver, []string{"a", "b", "c"}, 0.9)
+		if err != nil {
+			t.Fatalf("NewResolver(%s): %v", tt.resolver, err)
+		}
+		if r.Name() != tt.resolver {
+			t.Errorf("resolver %s named %s", tt.resolver, r.Name())
+		}
+		if got := r.Resolve(tt.calls, 2, 1); got != tt.want {
+			t.Errorf("%s: %v resolved to %s, want %s", tt.resolver, tt.calls, got, tt.want)
+		}
+	}
+}
+
+func TestResolverPriorityOrder(t *testing.T) {
+	calls := test_calls(nil, "0/1:1.000:0,1,0:A", "1/1:2.000:0,0,1:B")
+	r, err := NewResolver(ResolvePriority, []string{"b", "a"}, 0.9)
+	if err != nil {
+		t.Fatalf("NewResolver: %v", err)
+	}
+	if got := r.Resolve(calls, 2, 1); got != "1/1:2.000:0,0,1:B" {
+		t.Errorf("b ranked first: resolved to %s", got)
+	}
+}
+
+func TestNewResolverUnknown(t *testing.T) {
+	if _, err := NewResolver("best", nil, 0.9); err == nil {
+		t.Errorf("unknown resolver: no error")
+	}
+}